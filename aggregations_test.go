@@ -0,0 +1,219 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"strings"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var aggregationsDefs = MustNewDefinitions(strings.NewReader(`
+view income {
+	amount number[2]
+}
+
+worksheet salary implements income {
+	1:employer text
+	2:amount number[2]
+	3:is_verified bool
+}
+
+worksheet rental implements income {
+	1:address text
+	2:amount number[0]
+}
+
+worksheet tax_return {
+	1:incomes []income
+	2:salaries []salary
+	3:deductions []number[2]
+	4:flags []bool
+
+	5:total_income number[2] computed_by {
+		return sum(incomes.amount)
+	}
+	6:total_deductions number[2] computed_by {
+		return sum(deductions)
+	}
+	7:num_incomes number[0] computed_by {
+		return count(incomes)
+	}
+	8:any_verified bool computed_by {
+		return any(salaries.is_verified)
+	}
+	9:all_verified bool computed_by {
+		return all(salaries.is_verified)
+	}
+	10:any_flag bool computed_by {
+		return any(flags)
+	}
+	11:employers []text computed_by {
+		return salaries.employer
+	}
+}`))
+
+func (s *Zuite) newSalary(amount string, isVerified bool) *Worksheet {
+	salary := aggregationsDefs.MustNewWorksheet("salary")
+	salary.MustSet("amount", MustNewValue(amount))
+	salary.MustSet("is_verified", &Bool{isVerified})
+	return salary
+}
+
+func (s *Zuite) TestAggregations_parse() {
+	def := aggregationsDefs.defs["tax_return"]
+	require.Equal(s.T(), &tReturn{&tCall{"sum", []expression{&tSelector{&tVar{"incomes"}, "amount"}}, nil}}, def.fieldsByName["total_income"].computedBy)
+	require.Equal(s.T(), []string{"incomes"}, def.fieldsByName["total_income"].computedBy.Args())
+	require.Equal(s.T(), []*Field{def.fieldsByName["total_income"], def.fieldsByName["num_incomes"]}, s.dependentsOf(def, "incomes"))
+}
+
+func (s *Zuite) dependentsOf(def *Definition, name string) []*Field {
+	var fields []*Field
+	for _, index := range def.dependents[def.fieldsByName[name].index] {
+		fields = append(fields, def.fieldsByIndex[index])
+	}
+	return fields
+}
+
+func (s *Zuite) TestAggregations_appendAndDelRecompute() {
+	var (
+		ws     = aggregationsDefs.MustNewWorksheet("tax_return")
+		salary = s.newSalary("1000.50", true)
+		rental = aggregationsDefs.MustNewWorksheet("rental")
+	)
+	rental.MustSet("amount", MustNewValue("300"))
+
+	ws.MustAppend("incomes", salary)
+	require.Equal(s.T(), "1000.50", ws.MustGet("total_income").String())
+	require.Equal(s.T(), "1", ws.MustGet("num_incomes").String())
+
+	ws.MustAppend("incomes", rental)
+	require.Equal(s.T(), "1300.50", ws.MustGet("total_income").String())
+	require.Equal(s.T(), "2", ws.MustGet("num_incomes").String())
+
+	ws.MustDel("incomes", 0)
+	require.Equal(s.T(), "300.00", ws.MustGet("total_income").String())
+	require.Equal(s.T(), "1", ws.MustGet("num_incomes").String())
+
+	ws.MustDel("incomes", 0)
+	require.Equal(s.T(), "0.00", ws.MustGet("total_income").String())
+	require.Equal(s.T(), "0", ws.MustGet("num_incomes").String())
+
+	ws.MustAppend("deductions", MustNewValue("10.25"))
+	ws.MustAppend("deductions", MustNewValue("5"))
+	require.Equal(s.T(), "15.25", ws.MustGet("total_deductions").String())
+}
+
+func (s *Zuite) TestAggregations_undefined() {
+	ws := aggregationsDefs.MustNewWorksheet("tax_return")
+
+	// elements with undefined fields make sums undefined
+	ws.MustAppend("incomes", aggregationsDefs.MustNewWorksheet("rental"))
+	require.Equal(s.T(), "undefined", ws.MustGet("total_income").String())
+	require.Equal(s.T(), "1", ws.MustGet("num_incomes").String())
+
+	// any is true as soon as one element is true, and all is false as soon
+	// as one element is false, regardless of undefined elements
+	ws.MustAppend("salaries", aggregationsDefs.MustNewWorksheet("salary"))
+	require.Equal(s.T(), "undefined", ws.MustGet("any_verified").String())
+	require.Equal(s.T(), "undefined", ws.MustGet("all_verified").String())
+
+	ws.MustAppend("salaries", s.newSalary("1", true))
+	require.Equal(s.T(), "true", ws.MustGet("any_verified").String())
+	require.Equal(s.T(), "undefined", ws.MustGet("all_verified").String())
+
+	ws.MustAppend("salaries", s.newSalary("1", false))
+	require.Equal(s.T(), "true", ws.MustGet("any_verified").String())
+	require.Equal(s.T(), "false", ws.MustGet("all_verified").String())
+}
+
+func (s *Zuite) TestAggregations_anyAllOverEmptyAndFull() {
+	ws := aggregationsDefs.MustNewWorksheet("tax_return")
+
+	ws.MustAppend("salaries", s.newSalary("1", true))
+	require.Equal(s.T(), "true", ws.MustGet("any_verified").String())
+	require.Equal(s.T(), "true", ws.MustGet("all_verified").String())
+
+	ws.MustDel("salaries", 0)
+	require.Equal(s.T(), "false", ws.MustGet("any_verified").String())
+	require.Equal(s.T(), "true", ws.MustGet("all_verified").String())
+
+	ws.MustAppend("flags", &Bool{false})
+	require.Equal(s.T(), "false", ws.MustGet("any_flag").String())
+	ws.MustAppend("flags", &Bool{true})
+	require.Equal(s.T(), "true", ws.MustGet("any_flag").String())
+}
+
+func (s *Zuite) TestAggregations_projection() {
+	ws := aggregationsDefs.MustNewWorksheet("tax_return")
+
+	acme := s.newSalary("1", true)
+	acme.MustSet("employer", NewText("Acme"))
+	ws.MustAppend("salaries", acme)
+	ws.MustAppend("salaries", s.newSalary("2", true))
+
+	require.Equal(s.T(), []Value{NewText("Acme"), &Undefined{}}, ws.MustGetSlice("employers"))
+}
+
+func (s *Zuite) TestAggregations_elementEditsPropagate() {
+	var (
+		ws     = aggregationsDefs.MustNewWorksheet("tax_return")
+		salary = s.newSalary("1000.50", false)
+		rental = aggregationsDefs.MustNewWorksheet("rental")
+	)
+	ws.MustAppend("incomes", salary)
+	ws.MustAppend("incomes", rental)
+	ws.MustAppend("salaries", salary)
+	require.Equal(s.T(), "undefined", ws.MustGet("total_income").String())
+
+	rental.MustSet("amount", MustNewValue("300"))
+	require.Equal(s.T(), "1300.50", ws.MustGet("total_income").String())
+
+	salary.MustSet("is_verified", &Bool{true})
+	require.Equal(s.T(), "true", ws.MustGet("all_verified").String())
+
+	// deleted elements no longer propagate, unless held elsewhere
+	ws.MustDel("incomes", 0)
+	require.Equal(s.T(), map[int]int{ws.def.fieldsByName["salaries"].index: 1}, salary.parents[ws])
+	salary.MustSet("amount", MustNewValue("2000"))
+	require.Equal(s.T(), "300.00", ws.MustGet("total_income").String())
+
+	ws.MustDel("salaries", 0)
+	require.Empty(s.T(), salary.parents)
+}
+
+func (s *Zuite) TestAggregations_errors() {
+	cases := map[string]string{
+		`return sum(names)`:        `sum: elements must be numbers, found text`,
+		`return any(names)`:        `any: elements must be bools, found text`,
+		`return all(names)`:        `all: elements must be bools, found text`,
+		`return sum(names.foo)`:    `cannot select foo on []text`,
+		`return sum(people.foo)`:   `unknown field simple.foo`,
+		`return sum(name)`:         `sum: argument 1 must be slice, found text`,
+		`return count(name.first)`: `cannot select first on text`,
+	}
+	for body, expected := range cases {
+		_, err := NewDefinitions(strings.NewReader(`
+		worksheet simple {
+			1:name text
+		}
+		worksheet aggregating {
+			1:name text
+			2:names []text
+			3:people []simple
+			4:result number[0] computed_by { ` + body + ` }
+		}`))
+		assert.EqualError(s.T(), err, "9:4: aggregating.result: "+expected, body)
+	}
+}
@@ -0,0 +1,453 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"unicode/utf8"
+)
+
+// argKind describes the values a builtin accepts as argument.
+type argKind string
+
+const (
+	argAny        argKind = "any value"
+	argText       argKind = "text"
+	argNumber     argKind = "number"
+	argWhole      argKind = "number[0]"
+	argComparable argKind = "number, text, date, or time"
+	argSized      argKind = "text, slice, or map"
+	argSlice      argKind = "slice"
+)
+
+// builtin is a function of the expression language, e.g. `len(name)`.
+type builtin struct {
+	// params holds the kinds of the arguments of the builtin. When variadic,
+	// the last kind applies to all remaining arguments, of which there must be
+	// at least one.
+	params   []argKind
+	variadic bool
+
+	// handlesUndefined is set for builtins computing over undefined arguments.
+	// All other builtins are undefined as soon as one argument is undefined.
+	handlesUndefined bool
+
+	compute func(args []Value) (Value, error)
+
+	// typeOf statically determines the type of the result from the types of
+	// the arguments, which are nil when unknown.
+	typeOf func(args []Type) (Type, error)
+}
+
+// returns is used for builtins whose result is always of the same type.
+func returns(typ Type) func(args []Type) (Type, error) {
+	return func(_ []Type) (Type, error) {
+		return typ, nil
+	}
+}
+
+// builtins holds all builtins by name. The builtin round is handled
+// separately, since its rounding mode and scale are not expressions.
+var builtins = map[string]*builtin{
+	"len": {
+		params: []argKind{argSized},
+		typeOf: returns(&tNumberType{0}),
+		compute: func(args []Value) (Value, error) {
+			var n int
+			switch v := args[0].(type) {
+			case *Text:
+				n = utf8.RuneCountInString(v.value)
+			case *slice:
+				n = len(v.elements)
+			case *mapValue:
+				n = len(v.entries)
+			}
+			return &Number{int64(n), &tNumberType{0}}, nil
+		},
+	},
+	"substr": {
+		params: []argKind{argText, argWhole, argWhole},
+		typeOf: returns(&tTextType{}),
+		compute: func(args []Value) (Value, error) {
+			var (
+				runes = []rune(args[0].(*Text).value)
+				start = args[1].(*Number).value
+				end   = args[2].(*Number).value
+			)
+			if start < 0 || end < start || int64(len(runes)) < end {
+				return nil, fmt.Errorf("substr: range [%d, %d) out of bounds of text of length %d", start, end, len(runes))
+			}
+			return &Text{string(runes[start:end])}, nil
+		},
+	},
+	"upper": {
+		params: []argKind{argText},
+		typeOf: returns(&tTextType{}),
+		compute: func(args []Value) (Value, error) {
+			return &Text{strings.ToUpper(args[0].(*Text).value)}, nil
+		},
+	},
+	"lower": {
+		params: []argKind{argText},
+		typeOf: returns(&tTextType{}),
+		compute: func(args []Value) (Value, error) {
+			return &Text{strings.ToLower(args[0].(*Text).value)}, nil
+		},
+	},
+	"trim": {
+		params: []argKind{argText},
+		typeOf: returns(&tTextType{}),
+		compute: func(args []Value) (Value, error) {
+			return &Text{strings.TrimSpace(args[0].(*Text).value)}, nil
+		},
+	},
+	"contains": {
+		params: []argKind{argText, argText},
+		typeOf: returns(&tBoolType{}),
+		compute: func(args []Value) (Value, error) {
+			return &Bool{strings.Contains(args[0].(*Text).value, args[1].(*Text).value)}, nil
+		},
+	},
+	"min": {
+		params:   []argKind{argComparable},
+		variadic: true,
+		typeOf: func(args []Type) (Type, error) {
+			return extremumType("min", args)
+		},
+		compute: func(args []Value) (Value, error) {
+			return extremum(args, -1)
+		},
+	},
+	"max": {
+		params:   []argKind{argComparable},
+		variadic: true,
+		typeOf: func(args []Type) (Type, error) {
+			return extremumType("max", args)
+		},
+		compute: func(args []Value) (Value, error) {
+			return extremum(args, 1)
+		},
+	},
+	"sum": {
+		params: []argKind{argSlice},
+		typeOf: func(args []Type) (Type, error) {
+			elementType := sliceElementType(args[0])
+			if isKnown(elementType) && !isNumber(elementType) {
+				return nil, fmt.Errorf("sum: elements must be numbers, found %s", elementType)
+			}
+			if !isNumber(elementType) {
+				return nil, nil
+			}
+			return elementType, nil
+		},
+		compute: func(args []Value) (Value, error) {
+			// The sum is at the scale of the slice's elements, e.g. summing
+			// a []number[2] yields a number[2].
+			var (
+				elements = args[0].(*slice)
+				scale    int
+				nums     []*Number
+			)
+			if typ, ok := elements.typ.elementType.(*tNumberType); ok {
+				scale = typ.scale
+			}
+			for _, element := range elements.elements {
+				switch v := element.value.(type) {
+				case *Undefined:
+					return v, nil
+				case *Number:
+					if scale < v.typ.scale {
+						scale = v.typ.scale
+					}
+					nums = append(nums, v)
+				default:
+					return nil, fmt.Errorf("sum: elements must be numbers, found %s", v.Type())
+				}
+			}
+			total := new(big.Int)
+			for _, num := range nums {
+				total.Add(total, num.scaleUp(scale))
+			}
+			return newNumberFromBig(total, scale)
+		},
+	},
+	"count": {
+		params: []argKind{argSlice},
+		typeOf: returns(&tNumberType{0}),
+		compute: func(args []Value) (Value, error) {
+			return &Number{int64(len(args[0].(*slice).elements)), &tNumberType{0}}, nil
+		},
+	},
+	"any": {
+		params: []argKind{argSlice},
+		typeOf: func(args []Type) (Type, error) {
+			return quantifyType("any", args[0])
+		},
+		compute: func(args []Value) (Value, error) {
+			return quantify("any", args[0].(*slice), true)
+		},
+	},
+	"all": {
+		params: []argKind{argSlice},
+		typeOf: func(args []Type) (Type, error) {
+			return quantifyType("all", args[0])
+		},
+		compute: func(args []Value) (Value, error) {
+			return quantify("all", args[0].(*slice), false)
+		},
+	},
+	"abs": {
+		params: []argKind{argNumber},
+		typeOf: func(args []Type) (Type, error) {
+			return args[0], nil
+		},
+		compute: func(args []Value) (Value, error) {
+			num := args[0].(*Number)
+			if num.value < 0 {
+				return newNumberFromBig(new(big.Int).Neg(big.NewInt(num.value)), num.typ.scale)
+			}
+			return num, nil
+		},
+	},
+	"coalesce": {
+		params:           []argKind{argAny, argAny},
+		variadic:         true,
+		handlesUndefined: true,
+		typeOf: func(args []Type) (Type, error) {
+			return joinTypes(args), nil
+		},
+		compute: func(args []Value) (Value, error) {
+			for _, arg := range args {
+				if _, ok := arg.(*Undefined); !ok {
+					return arg, nil
+				}
+			}
+			return &Undefined{}, nil
+		},
+	},
+	"is_defined": {
+		params:           []argKind{argAny},
+		handlesUndefined: true,
+		typeOf:           returns(&tBoolType{}),
+		compute: func(args []Value) (Value, error) {
+			_, ok := args[0].(*Undefined)
+			return &Bool{!ok}, nil
+		},
+	},
+}
+
+// extremum returns the smallest of args when sign is -1, and the largest when
+// sign is 1.
+func extremum(args []Value, sign int) (Value, error) {
+	result := args[0]
+	for _, arg := range args[1:] {
+		cmp, err := compare(arg, result)
+		if err != nil {
+			return nil, err
+		}
+		if cmp*sign > 0 {
+			result = arg
+		}
+	}
+	return result, nil
+}
+
+// sliceElementType returns the element type of a slice type, or nil when the
+// type is unknown.
+func sliceElementType(typ Type) Type {
+	if slice, ok := typ.(*SliceType); ok {
+		return slice.elementType
+	}
+	return nil
+}
+
+func quantifyType(name string, typ Type) (Type, error) {
+	if elementType := sliceElementType(typ); isKnown(elementType) && !isBool(elementType) {
+		return nil, fmt.Errorf("%s: elements must be bools, found %s", name, elementType)
+	}
+	return &tBoolType{}, nil
+}
+
+func extremumType(name string, args []Type) (Type, error) {
+	var first Type
+	for _, arg := range args {
+		if !isKnown(arg) {
+			continue
+		} else if first == nil {
+			first = arg
+		} else if !orderable(first, arg) {
+			return nil, fmt.Errorf("%s: cannot compare %s and %s", name, first, arg)
+		}
+	}
+	return joinTypes(args), nil
+}
+
+// quantify computes whether any element of a slice of bools is true, when
+// decisive is true, or whether all elements are true, when decisive is false.
+// Undefined elements make the result undefined, unless an element with the
+// decisive value is present.
+func quantify(name string, elements *slice, decisive bool) (Value, error) {
+	var sawUndefined bool
+	for _, element := range elements.elements {
+		switch v := element.value.(type) {
+		case *Undefined:
+			sawUndefined = true
+		case *Bool:
+			if v.value == decisive {
+				return v, nil
+			}
+		default:
+			return nil, fmt.Errorf("%s: elements must be bools, found %s", name, v.Type())
+		}
+	}
+	if sawUndefined {
+		return &Undefined{}, nil
+	}
+	return &Bool{!decisive}, nil
+}
+
+// checkArity verifies the number of arguments given to the builtin.
+func (b *builtin) checkArity(name string, num int) error {
+	switch {
+	case b.variadic && num < len(b.params):
+		return fmt.Errorf("%s takes at least %d %s, found %d", name, len(b.params), pluralArguments(len(b.params)), num)
+	case !b.variadic && num != len(b.params):
+		return fmt.Errorf("%s takes %d %s, found %d", name, len(b.params), pluralArguments(len(b.params)), num)
+	}
+	return nil
+}
+
+func pluralArguments(num int) string {
+	if num == 1 {
+		return "argument"
+	}
+	return "arguments"
+}
+
+// checkArg verifies that the defined value given as i-th argument (counting
+// from 0) to the builtin is of the expected kind.
+func (b *builtin) checkArg(name string, i int, value Value) error {
+	kind := b.argKind(i)
+
+	var ok bool
+	switch kind {
+	case argAny:
+		ok = true
+	case argText:
+		_, ok = value.(*Text)
+	case argNumber:
+		_, ok = value.(*Number)
+	case argWhole:
+		num, isNum := value.(*Number)
+		ok = isNum && num.typ.scale == 0
+	case argComparable:
+		switch value.(type) {
+		case *Number, *Text, *Date, *Time:
+			ok = true
+		}
+	case argSized:
+		switch value.(type) {
+		case *Text, *slice, *mapValue:
+			ok = true
+		}
+	case argSlice:
+		_, ok = value.(*slice)
+	default:
+		panic(fmt.Sprintf("unknown argument kind %s", kind))
+	}
+	if !ok {
+		return fmt.Errorf("%s: argument %d must be %s, found %s", name, i+1, kind, value.Type())
+	}
+	return nil
+}
+
+// argKind returns the kind of the i-th argument (counting from 0).
+func (b *builtin) argKind(i int) argKind {
+	if i < len(b.params) {
+		return b.params[i]
+	}
+	return b.params[len(b.params)-1]
+}
+
+// acceptsArgType reports whether values of type typ can be given as i-th
+// argument (counting from 0) to the builtin.
+func (b *builtin) acceptsArgType(i int, typ Type) bool {
+	switch b.argKind(i) {
+	case argAny:
+		return true
+	case argText:
+		return isText(typ)
+	case argNumber:
+		return isNumber(typ)
+	case argWhole:
+		num, ok := typ.(*tNumberType)
+		return ok && num.scale == 0
+	case argComparable:
+		return orderable(typ, typ)
+	case argSized:
+		switch typ.(type) {
+		case *tTextType, *EnumType, *SliceType, *MapType:
+			return true
+		}
+	case argSlice:
+		_, ok := typ.(*SliceType)
+		return ok
+	}
+	return false
+}
+
+func (e *tCall) Args() []string {
+	var args []string
+	for _, arg := range e.args {
+		args = append(args, arg.Args()...)
+	}
+	return args
+}
+
+func (e *tCall) Compute(ws *Worksheet, locals map[string]Value) (Value, error) {
+	args := make([]Value, len(e.args))
+	for i, arg := range e.args {
+		value, err := arg.Compute(ws, locals)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = value
+	}
+
+	if e.round != nil {
+		if _, ok := args[0].(*Undefined); ok {
+			return args[0], nil
+		}
+		num, ok := args[0].(*Number)
+		if !ok {
+			return nil, fmt.Errorf("round: argument 1 must be number, found %s", args[0].Type())
+		}
+		return num.Round(e.round.mode, e.round.scale)
+	}
+
+	b := builtins[e.name]
+	for i, arg := range args {
+		if _, ok := arg.(*Undefined); ok {
+			if b.handlesUndefined {
+				continue
+			}
+			return arg, nil
+		}
+		if err := b.checkArg(e.name, i, arg); err != nil {
+			return nil, err
+		}
+	}
+	return b.compute(args)
+}
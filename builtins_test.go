@@ -0,0 +1,226 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"strings"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (s *Zuite) TestBuiltins_parse() {
+	cases := map[string]expression{
+		`len("a")`: &tCall{"len", []expression{&Text{"a"}}, nil},
+		`min(a, b + 1, 3)`: &tCall{"min", []expression{
+			&tVar{"a"},
+			&tBinop{opPlus, &tVar{"b"}, &Number{1, &tNumberType{0}}, nil},
+			&Number{3, &tNumberType{0}},
+		}, nil},
+		`round(a, half, 2)`: &tCall{"round", []expression{&tVar{"a"}}, &tRound{ModeHalf, 2}},
+		`len(a) + 1`: &tBinop{
+			opPlus,
+			&tCall{"len", []expression{&tVar{"a"}}, nil},
+			&Number{1, &tNumberType{0}},
+			nil,
+		},
+		`upper(lower(a))`: &tCall{"upper", []expression{&tCall{"lower", []expression{&tVar{"a"}}, nil}}, nil},
+	}
+	for input, expected := range cases {
+		p := newParser(strings.NewReader(input))
+		actual, err := p.parseExpression(true)
+		require.NoError(s.T(), err, input)
+		require.Equal(s.T(), "", p.next(), "%s should have reached eof", input)
+		assert.Equal(s.T(), expected, actual, input)
+	}
+}
+
+func (s *Zuite) TestBuiltins_compute() {
+	cases := map[string]string{
+		`len("")`:                       `0`,
+		`len("Alice")`:                  `5`,
+		`len("héllo")`:                  `5`,
+		`len(undefined)`:                `undefined`,
+		`len("ab") * 2`:                 `4`,
+		`len("abc") > 2`:                `true`,
+		`substr("Alice", 1, 3)`:         `"li"`,
+		`substr("Alice", 0, 5)`:         `"Alice"`,
+		`substr("Alice", 2, 2)`:         `""`,
+		`substr("héllo", 1, 2)`:         `"é"`,
+		`substr(undefined, 1, 2)`:       `undefined`,
+		`substr("Alice", undefined, 2)`: `undefined`,
+
+		`upper("Alice")`:     `"ALICE"`,
+		`lower("Alice")`:     `"alice"`,
+		`upper(undefined)`:   `undefined`,
+		`trim("  Alice \t")`: `"Alice"`,
+		`trim(undefined)`:    `undefined`,
+
+		`contains("Alice", "lic")`:     `true`,
+		`contains("Alice", "Bob")`:     `false`,
+		`contains("Alice", "")`:        `true`,
+		`contains(undefined, "a")`:     `undefined`,
+		`contains("Alice", undefined)`: `undefined`,
+
+		`min(3)`:        `3`,
+		`min(3, 1, 2)`:  `1`,
+		`max(3, 1, 2)`:  `3`,
+		`min(1.5, 2)`:   `1.5`,
+		`max(1.5, 2)`:   `2`,
+		`min(-1, 1)`:    `-1`,
+		`min("b", "a")`: `"a"`,
+		`max(date("2018-01-01"), date("2018-02-01"))`: `date("2018-02-01")`,
+		`min(1, undefined)`:                           `undefined`,
+		`max(undefined, 1)`:                           `undefined`,
+
+		`abs(-5)`:        `5`,
+		`abs(5.25)`:      `5.25`,
+		`abs(-5.25)`:     `5.25`,
+		`abs(undefined)`: `undefined`,
+
+		`round(1.25, up, 1)`:      `1.3`,
+		`round(1.25, down, 1)`:    `1.2`,
+		`round(1.25, half, 1)`:    `1.3`,
+		`round(1.2, up, 0)`:       `2`,
+		`round(undefined, up, 0)`: `undefined`,
+
+		`coalesce(undefined, 1)`:              `1`,
+		`coalesce(2, 1)`:                      `2`,
+		`coalesce(undefined, undefined)`:      `undefined`,
+		`coalesce(undefined, undefined, "a")`: `"a"`,
+
+		`is_defined(undefined)`:  `false`,
+		`is_defined(1)`:          `true`,
+		`is_defined("")`:         `true`,
+		`!is_defined(undefined)`: `true`,
+	}
+	for input, expected := range cases {
+		p := newParser(strings.NewReader(input))
+		expr, err := p.parseExpression(true)
+		require.NoError(s.T(), err, input)
+		actual, err := expr.Compute(nil, nil)
+		require.NoError(s.T(), err, input)
+
+		p = newParser(strings.NewReader(expected))
+		expectedExpr, err := p.parseExpression(true)
+		require.NoError(s.T(), err, expected)
+		expectedValue, err := expectedExpr.Compute(nil, nil)
+		require.NoError(s.T(), err, expected)
+		assert.Equal(s.T(), expectedValue.String(), actual.String(), input)
+	}
+}
+
+func (s *Zuite) TestBuiltins_parseErrors() {
+	cases := map[string]string{
+		`foo(1)`:                `unknown function foo`,
+		`len()`:                 `len takes 1 argument, found 0`,
+		`len("a", "b")`:         `len takes 1 argument, found 2`,
+		`substr("a", 1)`:        `substr takes 3 arguments, found 2`,
+		`min()`:                 `min takes at least 1 argument, found 0`,
+		`coalesce(1)`:           `coalesce takes at least 2 arguments, found 1`,
+		`is_defined()`:          `is_defined takes 1 argument, found 0`,
+		`len(5)`:                `len: argument 1 must be text, slice, or map, found number[0]`,
+		`upper(true)`:           `upper: argument 1 must be text, found bool`,
+		`substr("a", 1.5, 2)`:   `substr: argument 2 must be number[0], found number[1]`,
+		`substr("a", 0, "b")`:   `substr: argument 3 must be number[0], found text`,
+		`contains("a", 1)`:      `contains: argument 2 must be text, found number[0]`,
+		`min(1, true)`:          `min: argument 2 must be number, text, date, or time, found bool`,
+		`abs("a")`:              `abs: argument 1 must be number, found text`,
+		`round("a", up, 2)`:     `round: argument 1 must be number, found text`,
+		`round(1, sideways, 2)`: `expecting rounding mode (up, down, ceiling, floor, half, half_down, or even)`,
+		`round(1, up)`:          `expected ,, found )`,
+		`round(1 up 2)`:         `expected ,, found up`,
+		`len("a"`:               `expected ,, found `,
+	}
+	for input, expected := range cases {
+		p := newParser(strings.NewReader(input))
+		_, err := p.parseExpression(true)
+		assert.EqualError(s.T(), err, expected, input)
+	}
+}
+
+func (s *Zuite) TestBuiltins_typeErrors() {
+	_, err := NewDefinitions(strings.NewReader(`worksheet simple {
+		1:name text
+		2:age number[0]
+		3:rate number[2]
+		4:upper_age text computed_by { return upper(age) }
+		5:short_name text computed_by { return substr(name, 0, rate) }
+		6:rounded_name number[0] computed_by { return round(name, up, 0) }
+		7:smallest number[0] computed_by { return min(age, name) }
+	}`))
+	require.EqualError(s.T(), err, strings.Join([]string{
+		`5:3: simple.upper_age: upper: argument 1 must be text, found number[0]`,
+		`6:3: simple.short_name: substr: argument 3 must be number[0], found number[2]`,
+		`7:3: simple.rounded_name: round: argument 1 must be number, found text`,
+		`8:3: simple.smallest: min: cannot compare number[0] and text`,
+	}, "\n"))
+}
+
+func (s *Zuite) TestBuiltins_computeErrors() {
+	defs := MustNewDefinitions(strings.NewReader(`worksheet simple {
+		1:name text
+		2:end number[0]
+		3:short_name text computed_by { return substr(name, 0, end) }
+	}`))
+
+	ws := defs.MustNewWorksheet("simple")
+	_, err := ws.ComputeEdit(NewEdit().Set("name", alice).Set("end", MustNewValue("9")))
+	assert.EqualError(s.T(), err, `substr: range [0, 9) out of bounds of text of length 5`)
+
+	defs = MustNewDefinitions(strings.NewReader(`worksheet simple {
+		1:amount number[2]
+		2:lowest number[2] computed_by { return amount - 92233720368547758.07 - 0.01 }
+		3:abs_lowest number[2] computed_by { return abs(lowest) }
+	}`))
+
+	ws = defs.MustNewWorksheet("simple")
+	_, err = ws.ComputeEdit(NewEdit().Set("amount", MustNewValue("0.00")))
+	assert.EqualError(s.T(), err, `number[2] overflow`)
+}
+
+func (s *Zuite) TestBuiltins_inWorksheet() {
+	defs := MustNewDefinitions(strings.NewReader(`worksheet simple {
+		1:first_name text
+		2:nickname text
+		3:display_name text computed_by {
+			return upper(trim(coalesce(nickname, first_name)))
+		}
+		4:has_nickname bool computed_by {
+			return is_defined(nickname)
+		}
+		5:names []text
+		6:num_names number[0] computed_by {
+			return len(names)
+		}
+	}`))
+
+	def := defs.defs["simple"]
+	require.Equal(s.T(), []string{"nickname", "first_name"}, def.fieldsByName["display_name"].computedBy.Args())
+
+	ws := defs.MustNewWorksheet("simple")
+	ws.MustSet("first_name", NewText(" Alice "))
+	require.Equal(s.T(), `"ALICE"`, ws.MustGet("display_name").String())
+
+	ws.MustSet("nickname", NewText("Ali"))
+	require.Equal(s.T(), `"ALI"`, ws.MustGet("display_name").String())
+	require.Equal(s.T(), `true`, ws.MustGet("has_nickname").String())
+
+	ws.MustUnset("nickname")
+	require.Equal(s.T(), `"ALICE"`, ws.MustGet("display_name").String())
+	require.Equal(s.T(), `false`, ws.MustGet("has_nickname").String())
+
+	ws.MustAppend("names", alice)
+	ws.MustAppend("names", bob)
+	require.Equal(s.T(), `2`, ws.MustGet("num_names").String())
+}
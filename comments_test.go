@@ -0,0 +1,94 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"strings"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (s *Zuite) TestComments_doc() {
+	defs := MustNewDefinitions(strings.NewReader(`
+	// A borrower applying for a loan.
+	worksheet borrower {
+		// The legal name of the borrower,
+		// as printed on their ID.
+		1:name text
+
+		2:age number[0] // in years
+
+		// Not a doc, since a blank line follows.
+
+		3:income number[2]
+
+		/* Block comments are not docs. */
+		4:debts number[2]
+
+		// Whether the borrower is an adult.
+		5:is_adult bool computed_by {
+			// Comments within blocks are skipped.
+			return 18 <= age // as is this one
+		}
+	}
+
+	worksheet undocumented {
+		1:name text
+	}`))
+
+	borrower := defs.Definition("borrower")
+	assert.Equal(s.T(), "A borrower applying for a loan.", borrower.Doc())
+
+	cases := map[string]string{
+		"name":     "The legal name of the borrower,\nas printed on their ID.",
+		"age":      "",
+		"income":   "",
+		"debts":    "",
+		"is_adult": "Whether the borrower is an adult.",
+		"id":       "",
+	}
+	for name, expected := range cases {
+		assert.Equal(s.T(), expected, borrower.FieldByName(name).Doc(), name)
+	}
+
+	assert.Equal(s.T(), "", defs.Definition("undocumented").Doc())
+
+	ws := defs.MustNewWorksheet("borrower")
+	ws.MustSet("age", MustNewValue("21"))
+	require.Equal(s.T(), "true", ws.MustGet("is_adult").String())
+}
+
+func (s *Zuite) TestComments_trailingCommentIsNotDoc() {
+	defs := MustNewDefinitions(strings.NewReader(`worksheet simple {
+		1:name text // the name
+		2:age number[0]
+	}`))
+
+	assert.Equal(s.T(), "", defs.Definition("simple").FieldByName("age").Doc())
+}
+
+func (s *Zuite) TestComments_viewFields() {
+	defs := MustNewDefinitions(strings.NewReader(`
+	view named {
+		// The full name.
+		name text
+	}
+
+	worksheet simple implements named {
+		1:name text
+	}`))
+
+	view := defs.Definition("simple").implements[0]
+	assert.Equal(s.T(), "The full name.", view.FieldByName("name").Doc())
+}
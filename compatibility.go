@@ -0,0 +1,344 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+)
+
+// ChangeKind is the kind of a breaking change between two versions of
+// definitions.
+type ChangeKind string
+
+const (
+	// ChangedType is a field index reused with a different type.
+	ChangedType ChangeKind = "changed_type"
+
+	// NarrowedScale is a number field whose scale was reduced, e.g. from
+	// number[2] to number[1].
+	NarrowedScale ChangeKind = "narrowed_scale"
+
+	// RemovedField is a field which was removed, and whose stored values can
+	// no longer be loaded.
+	RemovedField ChangeKind = "removed_field"
+
+	// RemovedWorksheet is a worksheet which was removed, and whose stored
+	// worksheets can no longer be loaded.
+	RemovedWorksheet ChangeKind = "removed_worksheet"
+
+	// ChangedComputedBy is a computed field whose stored values are stale, and
+	// must be backfilled, since it was added, its computed_by changed, it
+	// depends on such a field, or it was an input field.
+	ChangedComputedBy ChangeKind = "changed_computed_by"
+)
+
+// Change is a breaking change between two versions of definitions, i.e. a
+// change which data stored with the old version does not survive as is.
+type Change struct {
+	Kind ChangeKind
+
+	// Worksheet is the name of the worksheet changed. Field and Index
+	// identify the field changed, and are zero valued for worksheet changes.
+	Worksheet string
+	Field     string
+	Index     int
+
+	// Msg describes the change.
+	Msg string
+}
+
+func (c *Change) String() string {
+	if c.Field == "" {
+		return fmt.Sprintf("%s: %s", c.Worksheet, c.Msg)
+	}
+	return fmt.Sprintf("%s.%s: %s", c.Worksheet, c.Field, c.Msg)
+}
+
+// CheckCompatibility compares definitions from, used to store data, with
+// definitions to, and reports all breaking changes. Fields are matched by
+// index, so renaming a field is compatible, whereas reusing its index for a
+// different type is not. Widening the scale of a number is compatible.
+//
+// Removed fields and worksheets are always reported, use
+// Session.CheckCompatibility to only report those still present in stored
+// data.
+func CheckCompatibility(from, to *Definitions) []*Change {
+	var changes []*Change
+	for name, fromDef := range from.defs {
+		toDef, ok := to.defs[name]
+		if !ok {
+			changes = append(changes, &Change{
+				Kind:      RemovedWorksheet,
+				Worksheet: name,
+				Msg:       "worksheet removed",
+			})
+			continue
+		}
+		changes = append(changes, checkDefinitionCompatibility(fromDef, toDef)...)
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		if changes[i].Worksheet != changes[j].Worksheet {
+			return changes[i].Worksheet < changes[j].Worksheet
+		}
+		return changes[i].Index < changes[j].Index
+	})
+	return changes
+}
+
+func checkDefinitionCompatibility(from, to *Definition) []*Change {
+	var changes []*Change
+	change := func(kind ChangeKind, field *Field, format string, args ...interface{}) {
+		changes = append(changes, &Change{
+			Kind:      kind,
+			Worksheet: to.name,
+			Field:     field.name,
+			Index:     field.index,
+			Msg:       fmt.Sprintf(format, args...),
+		})
+	}
+
+	// stale holds the indexes of computed fields which must be backfilled.
+	stale := make(map[int]bool)
+	for _, fromField := range from.fields {
+		toField, ok := to.fieldsByIndex[fromField.index]
+		if !ok {
+			change(RemovedField, fromField, "index %d removed", fromField.index)
+			continue
+		}
+
+		switch typeCompatibility(fromField.typ, toField.typ) {
+		case ChangedType:
+			fromEnum, fromOk := fromField.typ.(*EnumType)
+			toEnum, toOk := toField.typ.(*EnumType)
+			if fromOk && toOk && fromEnum.name == toEnum.name {
+				change(ChangedType, toField, "index %d removed values from enum %s", toField.index, toEnum.name)
+			} else {
+				change(ChangedType, toField, "index %d changed from %s to %s", toField.index, fromField.typ, toField.typ)
+			}
+			continue
+		case NarrowedScale:
+			change(NarrowedScale, toField, "index %d narrowed from %s to %s", toField.index, fromField.typ, toField.typ)
+			continue
+		}
+
+		if toField.computedBy != nil && !sameComputedBy(from, to, fromField.computedBy, toField.computedBy) {
+			stale[toField.index] = true
+			if fromField.computedBy == nil {
+				change(ChangedComputedBy, toField, "input field became computed")
+			} else {
+				change(ChangedComputedBy, toField, "computed_by changed")
+			}
+		}
+	}
+
+	for _, toField := range to.fields {
+		if _, ok := from.fieldsByIndex[toField.index]; !ok && toField.computedBy != nil {
+			stale[toField.index] = true
+			change(ChangedComputedBy, toField, "computed field added")
+		}
+	}
+
+	// Computed fields come after the fields they depend on, so staleness
+	// propagates in a single pass.
+	for _, field := range to.computedFields {
+		if !stale[field.index] {
+			continue
+		}
+		for _, index := range to.dependents[field.index] {
+			if stale[index] {
+				continue
+			}
+			stale[index] = true
+			change(ChangedComputedBy, to.fieldsByIndex[index], "depends on %s, which must be backfilled", field.name)
+		}
+	}
+	return changes
+}
+
+// typeCompatibility reports whether values of type from can be read as
+// values of type to, returning ChangedType or NarrowedScale if not, and ""
+// otherwise.
+func typeCompatibility(from, to Type) ChangeKind {
+	switch f := from.(type) {
+	case *tNumberType:
+		if t, ok := to.(*tNumberType); ok {
+			if t.scale < f.scale {
+				return NarrowedScale
+			}
+			return ""
+		}
+	case *SliceType:
+		if t, ok := to.(*SliceType); ok {
+			return typeCompatibility(f.elementType, t.elementType)
+		}
+	case *TupleType:
+		if t, ok := to.(*TupleType); ok && len(t.elementTypes) == len(f.elementTypes) {
+			var kind ChangeKind
+			for i := range f.elementTypes {
+				switch typeCompatibility(f.elementTypes[i], t.elementTypes[i]) {
+				case ChangedType:
+					return ChangedType
+				case NarrowedScale:
+					kind = NarrowedScale
+				}
+			}
+			return kind
+		}
+	case *EnumType:
+		// Values may be added to an enum, but not removed.
+		if t, ok := to.(*EnumType); ok && t.name == f.name {
+			for _, value := range f.values {
+				if !t.has(value) {
+					return ChangedType
+				}
+			}
+			return ""
+		}
+	default:
+		// Types of different definitions are distinct, and are compared by
+		// name.
+		if reflect.TypeOf(from) == reflect.TypeOf(to) && from.String() == to.String() {
+			return ""
+		}
+	}
+	return ChangedType
+}
+
+// sameComputedBy reports whether the computed_by of a field of definition
+// from, and of a field of definition to, compute the same values. Externally
+// computed fields are assumed unchanged, since their plugins cannot be
+// compared.
+func sameComputedBy(from, to *Definition, x, y expression) bool {
+	switch y.(type) {
+	case *tExternal, *ePlugin:
+		switch x.(type) {
+		case *tExternal, *ePlugin:
+			return true
+		}
+		return false
+	}
+	return sameExpression(from, to, reflect.ValueOf(x), reflect.ValueOf(y))
+}
+
+var tVarType = reflect.TypeOf(&tVar{})
+
+// sameExpression reports whether expressions x and y are equal, like
+// reflect.DeepEqual, except that fields are compared by index rather than by
+// name, such that renaming a field does not change the expressions using it.
+func sameExpression(from, to *Definition, x, y reflect.Value) bool {
+	if x.IsValid() != y.IsValid() {
+		return false
+	} else if !x.IsValid() {
+		return true
+	}
+	if x.Type() != y.Type() {
+		return false
+	}
+
+	switch x.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if x.IsNil() || y.IsNil() {
+			return x.IsNil() == y.IsNil()
+		}
+		if x.Type() == tVarType {
+			xField := from.fieldsByName[x.Elem().Field(0).String()]
+			yField := to.fieldsByName[y.Elem().Field(0).String()]
+			return xField != nil && yField != nil && xField.index == yField.index
+		}
+		return sameExpression(from, to, x.Elem(), y.Elem())
+	case reflect.Struct:
+		for i := 0; i < x.NumField(); i++ {
+			if !sameExpression(from, to, x.Field(i), y.Field(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Slice:
+		if x.Len() != y.Len() {
+			return false
+		}
+		for i := 0; i < x.Len(); i++ {
+			if !sameExpression(from, to, x.Index(i), y.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.String:
+		return x.String() == y.String()
+	case reflect.Bool:
+		return x.Bool() == y.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return x.Int() == y.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return x.Uint() == y.Uint()
+	}
+	return false
+}
+
+// CheckCompatibility compares the definitions of the store with definitions
+// to, and reports all breaking changes, like CheckCompatibility. Removed
+// fields and worksheets are only reported if values are still stored for
+// them.
+func (s *Session) CheckCompatibility(to *Definitions) ([]*Change, error) {
+	var changes []*Change
+	for _, change := range CheckCompatibility(s.defs, to) {
+		var (
+			stored bool
+			err    error
+		)
+		switch change.Kind {
+		case RemovedWorksheet:
+			stored, err = s.hasWorksheets(change.Worksheet)
+		case RemovedField:
+			stored, err = s.hasValues(change.Worksheet, change.Index)
+		default:
+			stored = true
+		}
+		if err != nil {
+			return nil, err
+		}
+		if stored {
+			changes = append(changes, change)
+		}
+	}
+	return changes, nil
+}
+
+func (s *Session) hasWorksheets(name string) (bool, error) {
+	var count int
+	if err := s.tx.
+		Select("count(*)").
+		From("worksheets").
+		Where("name = $1", name).
+		QueryScalar(&count); err != nil {
+		return false, err
+	}
+	return count != 0, nil
+}
+
+func (s *Session) hasValues(name string, index int) (bool, error) {
+	var count int
+	if err := s.tx.
+		Select("count(*)").
+		From("worksheet_values").
+		Where("worksheet_id in (select id from worksheets where name = $1)", name).
+		Where("index = $1 and to_version = $2", index, math.MaxInt32).
+		QueryScalar(&count); err != nil {
+		return false, err
+	}
+	return count != 0, nil
+}
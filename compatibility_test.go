@@ -0,0 +1,237 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"strings"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+func changesToStrings(changes []*Change) []string {
+	var result []string
+	for _, change := range changes {
+		result = append(result, change.String())
+	}
+	return result
+}
+
+func (s *Zuite) TestCheckCompatibility_compatible() {
+	from := MustNewDefinitions(strings.NewReader(`
+	worksheet loan {
+		1:amount number[2]
+		2:rate number[3]
+		3:names []text
+		4:interest number[2] computed_by { return amount * rate round half 2 }
+		5:borrower borrower
+	}
+	worksheet borrower {
+		1:name text
+	}`))
+	to := MustNewDefinitions(strings.NewReader(`
+	// Docs, positions and names do not matter, only indexes do.
+	worksheet borrower {
+		1:full_name text
+		2:age number[0]
+	}
+	worksheet loan {
+		5:borrower borrower
+		1:principal number[3]
+		2:rate number[3]
+		3:names []text
+		4:interest number[2] computed_by { return principal * rate round half 2 }
+		6:term number[0]
+	}
+	worksheet servicing {
+		1:loan loan
+	}`))
+
+	require.Empty(s.T(), CheckCompatibility(from, to))
+}
+
+func (s *Zuite) TestCheckCompatibility_breaking() {
+	from := MustNewDefinitions(strings.NewReader(`
+	worksheet loan {
+		1:amount number[2]
+		2:rate number[3]
+		3:names []number[2]
+		4:interest number[2] computed_by { return amount * rate round half 2 }
+		5:yearly number[2] computed_by { return interest * 12 }
+		6:term number[0]
+		7:borrower borrower
+		8:notes text
+	}
+	worksheet borrower {
+		1:name text
+	}
+	worksheet removed {
+		1:name text
+	}`))
+	to := MustNewDefinitions(strings.NewReader(`
+	worksheet loan {
+		1:amount number[1]
+		2:rate text
+		3:names []number[0]
+		4:interest number[2] computed_by { return amount * 5 round half 2 }
+		5:yearly number[2] computed_by { return interest * 12 }
+		6:term number[0] computed_by { return amount round down 0 }
+		7:borrower other
+		9:notes text
+		10:monthly number[2] computed_by { return yearly / 12 round down 2 }
+	}
+	worksheet borrower {
+		1:name text
+	}
+	worksheet other {
+		1:name text
+	}`))
+
+	changes := CheckCompatibility(from, to)
+	require.Equal(s.T(), []string{
+		"loan.amount: index 1 narrowed from number[2] to number[1]",
+		"loan.rate: index 2 changed from number[3] to text",
+		"loan.names: index 3 narrowed from []number[2] to []number[0]",
+		"loan.interest: computed_by changed",
+		"loan.yearly: depends on interest, which must be backfilled",
+		"loan.term: input field became computed",
+		"loan.borrower: index 7 changed from borrower to other",
+		"loan.notes: index 8 removed",
+		"loan.monthly: computed field added",
+		"removed: worksheet removed",
+	}, changesToStrings(changes))
+
+	assert.Equal(s.T(), &Change{
+		Kind:      NarrowedScale,
+		Worksheet: "loan",
+		Field:     "amount",
+		Index:     1,
+		Msg:       "index 1 narrowed from number[2] to number[1]",
+	}, changes[0])
+	assert.Equal(s.T(), ChangedType, changes[1].Kind)
+	assert.Equal(s.T(), ChangedComputedBy, changes[4].Kind)
+	assert.Equal(s.T(), RemovedField, changes[7].Kind)
+	assert.Equal(s.T(), RemovedWorksheet, changes[9].Kind)
+}
+
+func (s *Zuite) TestCheckCompatibility_tuplesAndEnums() {
+	from := MustNewDefinitions(strings.NewReader(`
+	enum suffix { "Jr.", "Sr." }
+	worksheet borrower {
+		1:pair tuple[text, number[2]]
+		2:widened tuple[text, number[2]]
+		3:narrowed tuple[text, number[2]]
+		4:changed tuple[text, number[2]]
+		5:shortened tuple[text, number[2]]
+		6:suffix suffix
+		7:suffixes []suffix
+	}`))
+
+	to := MustNewDefinitions(strings.NewReader(`
+	enum suffix { "Jr.", "III" }
+	worksheet borrower {
+		1:pair tuple[text, number[2]]
+		2:widened tuple[text, number[3]]
+		3:narrowed tuple[text, number[1]]
+		4:changed tuple[text, text]
+		5:shortened tuple[text]
+		6:suffix suffix
+		7:suffixes []suffix
+	}`))
+	require.Equal(s.T(), []string{
+		"borrower.narrowed: index 3 narrowed from tuple[text, number[2]] to tuple[text, number[1]]",
+		"borrower.changed: index 4 changed from tuple[text, number[2]] to tuple[text, text]",
+		"borrower.shortened: index 5 changed from tuple[text, number[2]] to tuple[text]",
+		"borrower.suffix: index 6 removed values from enum suffix",
+		"borrower.suffixes: index 7 changed from []suffix to []suffix",
+	}, changesToStrings(CheckCompatibility(from, to)))
+
+	// Adding values to an enum is compatible.
+	added := MustNewDefinitions(strings.NewReader(`
+	enum suffix { "Jr.", "Sr.", "III" }
+	worksheet borrower {
+		1:pair tuple[text, number[2]]
+		2:widened tuple[text, number[2]]
+		3:narrowed tuple[text, number[2]]
+		4:changed tuple[text, number[2]]
+		5:shortened tuple[text, number[2]]
+		6:suffix suffix
+		7:suffixes []suffix
+	}`))
+	require.Empty(s.T(), CheckCompatibility(from, added))
+}
+
+func (s *Zuite) TestCheckCompatibility_externals() {
+	from := MustNewDefinitions(strings.NewReader(`
+	worksheet loan {
+		1:amount number[2]
+		2:greeting text computed_by { external }
+	}`), Options{
+		Plugins: map[string]map[string]ComputedBy{
+			"loan": {"greeting": sayAlice([]string{"amount"})},
+		},
+	})
+	to := MustNewDefinitions(strings.NewReader(`
+	worksheet loan {
+		1:amount number[2]
+		2:greeting text computed_by { external }
+	}`), Options{
+		Plugins: map[string]map[string]ComputedBy{
+			"loan": {"greeting": sayAlice([]string{"amount"})},
+		},
+	})
+
+	require.Empty(s.T(), CheckCompatibility(from, to))
+}
+
+func (s *DbZuite) TestCheckCompatibility_storedData() {
+	var (
+		from = MustNewDefinitions(strings.NewReader(`
+		worksheet loan {
+			1:amount number[2]
+			2:notes text
+		}
+		worksheet removed {
+			1:name text
+		}
+		worksheet unused {
+			1:name text
+		}`))
+		to = MustNewDefinitions(strings.NewReader(`
+		worksheet loan {
+			3:term number[0]
+		}`))
+		store = NewStore(from)
+	)
+	s.MustRunTransaction(func(tx *runner.Tx) error {
+		session := store.Open(tx)
+		loan := from.MustNewWorksheet("loan")
+		loan.MustSet("amount", MustNewValue("100.00"))
+		if err := session.Save(loan); err != nil {
+			return err
+		}
+		return session.Save(from.MustNewWorksheet("removed"))
+	})
+
+	var changes []*Change
+	s.MustRunTransaction(func(tx *runner.Tx) error {
+		var err error
+		changes, err = store.Open(tx).CheckCompatibility(to)
+		return err
+	})
+	require.Equal(s.T(), []string{
+		"loan.amount: index 1 removed",
+		"removed: worksheet removed",
+	}, changesToStrings(changes))
+}
@@ -32,7 +32,7 @@ func (s *Zuite) TestWorksheet_externalComputedBy() {
 				1:hello_name text computed_by { external }
 			}`,
 			nil,
-			"simple.hello_name: missing plugin for external computed_by",
+			"2:5: simple.hello_name: missing plugin for external computed_by",
 		},
 		{
 			`worksheet simple {}`,
@@ -81,7 +81,7 @@ func (s *Zuite) TestWorksheet_externalComputedBy() {
 					},
 				},
 			},
-			"simple.name has no dependencies",
+			"2:5: simple.name has no dependencies",
 		},
 		{
 			`worksheet simple {
@@ -95,7 +95,7 @@ func (s *Zuite) TestWorksheet_externalComputedBy() {
 					},
 				},
 			},
-			"simple.name references unknown arg agee",
+			"2:5: simple.name references unknown arg agee",
 		},
 	}
 	for _, ex := range cases {
@@ -285,22 +285,211 @@ func (s *Zuite) TestSimpleExpressionsInWorksheet() {
 	require.Equal(s.T(), "75", ws.MustGet("age_plus_two").String())
 }
 
-func (s *Zuite) TestCyclicEditsIfNoIdentCheck() {
-	defs, err := NewDefinitions(strings.NewReader(`worksheet cyclic_edits {
-		1:right bool
-		2:a bool computed_by {
-			return b || right
+func (s *Zuite) TestComputedBy_cyclesAreRejected() {
+	cases := map[string]string{
+		`worksheet cyclic_edits {
+			1:right bool
+			2:a bool computed_by {
+				return b || right
+			}
+			3:b bool computed_by {
+				return a || !right
+			}
+		}`: `3:4: cyclic_edits.a: computed_by cycle a -> b -> a`,
+
+		`worksheet self_cycle {
+			1:a bool computed_by {
+				return !a
+			}
+		}`: `2:4: self_cycle.a: computed_by cycle a -> a`,
+
+		`worksheet longer_cycle {
+			1:input number[0]
+			2:a number[0] computed_by { return input + b }
+			3:b number[0] computed_by { return c + 1 }
+			4:c number[0] computed_by { return d + 1 }
+			5:d number[0] computed_by { return b + 1 }
+		}`: `4:4: longer_cycle.b: computed_by cycle b -> c -> d -> b`,
+	}
+	for input, msg := range cases {
+		_, err := NewDefinitions(strings.NewReader(input))
+		assert.EqualError(s.T(), err, msg, input)
+	}
+}
+
+type countingPlus struct {
+	args  []string
+	count *int
+}
+
+var _ ComputedBy = countingPlus{}
+
+func (cp countingPlus) Args() []string {
+	return cp.args
+}
+
+func (cp countingPlus) Compute(values ...Value) Value {
+	*cp.count++
+	result := MustNewValue("0").(*Number)
+	for _, value := range values {
+		if _, ok := value.(*Undefined); ok {
+			return value
 		}
-		3:b bool computed_by {
-			return a || !right
+		sum, err := result.Plus(value.(*Number))
+		if err != nil {
+			panic(err)
+		}
+		result = sum
+	}
+	return result
+}
+
+func (s *Zuite) TestComputedBy_diamondIsComputedOnce() {
+	var count int
+	opt := Options{
+		Plugins: map[string]map[string]ComputedBy{
+			"diamond": map[string]ComputedBy{
+				"bottom": countingPlus{[]string{"left", "right"}, &count},
+			},
+		},
+	}
+	defs, err := NewDefinitions(strings.NewReader(`worksheet diamond {
+		1:bottom number[0] computed_by { external }
+		2:left number[0] computed_by { return top + 1 }
+		3:right number[0] computed_by { return top + 2 }
+		4:top number[0]
+	}`), opt)
+	require.NoError(s.T(), err)
+
+	def := defs.defs["diamond"]
+	require.Equal(s.T(), []*Field{
+		def.fieldsByName["left"],
+		def.fieldsByName["right"],
+		def.fieldsByName["bottom"],
+	}, def.computedFields)
+
+	ws := defs.MustNewWorksheet("diamond")
+
+	ws.MustSet("top", MustNewValue("1"))
+	require.Equal(s.T(), "5", ws.MustGet("bottom").String())
+	require.Equal(s.T(), 1, count)
+
+	ws.MustSet("top", MustNewValue("10"))
+	require.Equal(s.T(), "23", ws.MustGet("bottom").String())
+	require.Equal(s.T(), 2, count)
+}
+
+func (s *Zuite) TestComputedBy_statements() {
+	defs, err := NewDefinitions(strings.NewReader(`worksheet bracket {
+		1:income number[0]
+		2:is_married bool
+		3:rate number[0] computed_by {
+			threshold := 40000
+			if is_married {
+				threshold = threshold * 2
+			}
+			if income < threshold {
+				return 10
+			} else if income < threshold * 2 {
+				return 20
+			}
+			return 30
+		}
+		4:is_high bool computed_by {
+			high := rate == 30
+			return high
 		}
 	}`))
 	require.NoError(s.T(), err)
 
-	ws := defs.MustNewWorksheet("cyclic_edits")
+	def := defs.defs["bracket"]
+	require.Equal(s.T(), []string{"is_married", "income", "income"}, def.fieldsByName["rate"].computedBy.Args())
+	require.Equal(s.T(), []string{"rate"}, def.fieldsByName["is_high"].computedBy.Args())
+
+	ws := defs.MustNewWorksheet("bracket")
+
+	// undefined condition
+	ws.MustSet("income", MustNewValue("50000"))
+	require.Equal(s.T(), &Undefined{}, ws.MustGet("rate"))
+
+	ws.MustSet("is_married", &Bool{false})
+	require.Equal(s.T(), "20", ws.MustGet("rate").String())
+	require.Equal(s.T(), "false", ws.MustGet("is_high").String())
+
+	ws.MustSet("is_married", &Bool{true})
+	require.Equal(s.T(), "10", ws.MustGet("rate").String())
+
+	ws.MustSet("income", MustNewValue("200000"))
+	require.Equal(s.T(), "30", ws.MustGet("rate").String())
+	require.Equal(s.T(), "true", ws.MustGet("is_high").String())
+}
+
+func (s *Zuite) TestComputedBy_statementsErrors() {
+	_, err := NewDefinitions(strings.NewReader(`worksheet simple {
+		1:name text
+		2:greeting text computed_by {
+			if name {
+				return "Hello"
+			}
+			return "Bye"
+		}
+	}`))
+	require.EqualError(s.T(), err, "3:3: simple.greeting: if on non-bool")
+
+	_, err = NewDefinitions(strings.NewReader(`worksheet simple {
+		1:name text
+		2:greeting text computed_by {
+			if true {
+				y := name
+			}
+			return y
+		}
+	}`))
+	require.EqualError(s.T(), err, "3:3: simple.greeting references unknown arg y")
+}
+
+func (s *Zuite) TestComputedBy_moduloByZero() {
+	defs := MustNewDefinitions(strings.NewReader(`worksheet schedule {
+		1:remainder number[2]
+		2:installments number[0]
+		3:leftover number[2] computed_by {
+			return remainder % installments
+		}
+	}`))
+
+	ws := defs.MustNewWorksheet("schedule")
+	ws.MustSet("remainder", MustNewValue("10.50"))
+	ws.MustSet("installments", MustNewValue("4"))
+	require.Equal(s.T(), MustNewValue("2.50"), ws.MustGet("leftover"))
+
+	err := ws.Set("installments", MustNewValue("0"))
+	require.EqualError(s.T(), err, "modulo by zero")
+	require.Equal(s.T(), MustNewValue("4"), ws.MustGet("installments"))
+}
+
+func (s *Zuite) TestComputedBy_arithmeticErrors() {
+	cases := map[string]string{
+		`return amount / divisor round down 2`: `division by zero`,
+		`return amount div divisor`:            `division by zero`,
+		`return amount * 1000000000`:           `number[2] overflow`,
+		`return amount + 9223372036854775807`:  `number[2] overflow`,
+	}
+	for body, expected := range cases {
+		defs := MustNewDefinitions(strings.NewReader(`worksheet loan {
+			1:amount number[2]
+			2:divisor number[0]
+			3:result number[2] computed_by { ` + body + ` }
+		}`))
+
+		ws := defs.MustNewWorksheet("loan")
+		_, err := ws.ComputeEdit(NewEdit().
+			Set("amount", MustNewValue("100000000000.00")).
+			Set("divisor", MustNewValue("0")))
+		assert.EqualError(s.T(), err, expected, body)
+	}
+}
 
-	ws.MustSet("right", MustNewValue("true"))
-	require.Equal(s.T(), "true", ws.MustGet("right").String(), "right")
-	require.Equal(s.T(), "undefined", ws.MustGet("a").String(), "a")
-	require.Equal(s.T(), "undefined", ws.MustGet("b").String(), "b")
+func (s *Zuite) TestComputedBy_unassignedLocal() {
+	_, err := (&tLocal{"x"}).Compute(nil, map[string]Value{})
+	require.EqualError(s.T(), err, "local x used before being assigned")
 }
@@ -0,0 +1,119 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"strings"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var constrainedDefs = MustNewDefinitions(strings.NewReader(`
+worksheet constrained {
+	1:age number[0] constrained_by {
+		return !(age == 0)
+	}
+	2:age_plus_one number[0] computed_by {
+		return age + 1
+	}
+	3:nickname text
+	4:name text constrained_by {
+		return name != nickname
+	}
+}`))
+
+func (s *Zuite) TestConstrainedBy_parse() {
+	def := constrainedDefs.defs["constrained"]
+
+	require.Equal(s.T(),
+		&tReturn{&tUnop{opNot, &tBinop{opEqual, &tVar{"age"}, &Number{0, &tNumberType{0}}, nil}}},
+		def.fieldsByName["age"].constrainedBy)
+	require.Nil(s.T(), def.fieldsByName["nickname"].constrainedBy)
+}
+
+func (s *Zuite) TestConstrainedBy_satisfied() {
+	ws := constrainedDefs.MustNewWorksheet("constrained")
+
+	ws.MustSet("age", MustNewValue("5"))
+	require.Equal(s.T(), "5", ws.MustGet("age").String())
+	require.Equal(s.T(), "6", ws.MustGet("age_plus_one").String())
+
+	ws.MustSet("name", alice)
+	ws.MustSet("nickname", bob)
+	require.Equal(s.T(), alice, ws.MustGet("name"))
+	require.Equal(s.T(), bob, ws.MustGet("nickname"))
+}
+
+func (s *Zuite) TestConstrainedBy_undefinedIsSatisfied() {
+	ws := constrainedDefs.MustNewWorksheet("constrained")
+
+	ws.MustSet("age", MustNewValue("5"))
+	ws.MustUnset("age")
+	require.False(s.T(), ws.MustIsSet("age"))
+}
+
+func (s *Zuite) TestConstrainedBy_violatedLeavesWorksheetUntouched() {
+	ws := constrainedDefs.MustNewWorksheet("constrained")
+	ws.MustSet("age", MustNewValue("5"))
+
+	err := ws.Set("age", MustNewValue("0"))
+	require.EqualError(s.T(), err, "constrained.age: constraint not satisfied")
+	require.Equal(s.T(), &ConstraintError{
+		Worksheet: "constrained",
+		Field:     "age",
+	}, err)
+
+	require.Equal(s.T(), "5", ws.MustGet("age").String())
+	require.Equal(s.T(), "6", ws.MustGet("age_plus_one").String())
+}
+
+func (s *Zuite) TestConstrainedBy_violatedThroughOtherField() {
+	ws := constrainedDefs.MustNewWorksheet("constrained")
+	ws.MustSet("name", alice)
+
+	// editing nickname affects the constraint of name
+	err := ws.Set("nickname", alice)
+	require.EqualError(s.T(), err, "constrained.name: constraint not satisfied")
+	require.False(s.T(), ws.MustIsSet("nickname"))
+
+	ws.MustSet("nickname", bob)
+	err = ws.Set("name", bob)
+	require.EqualError(s.T(), err, "constrained.name: constraint not satisfied")
+	require.Equal(s.T(), alice, ws.MustGet("name"))
+}
+
+func (s *Zuite) TestConstrainedBy_definitionErrors() {
+	cases := map[string]string{
+		`worksheet simple {
+			1:name text
+			2:name_again text computed_by {
+				return name
+			} constrained_by {
+				return true
+			}
+		}`: `3:4: simple.name_again: computed fields cannot be constrained`,
+
+		`worksheet simple {
+			1:name text constrained_by { external }
+		}`: `2:4: simple.name: constrained_by cannot be external`,
+
+		`worksheet simple {
+			1:name text constrained_by { return name != nick }
+		}`: `2:4: simple.name references unknown arg nick`,
+	}
+	for input, msg := range cases {
+		_, err := NewDefinitions(strings.NewReader(input))
+		assert.EqualError(s.T(), err, msg, input)
+	}
+}
@@ -88,10 +88,22 @@ type rSliceElement struct {
 	Value       dat.NullString `db:"value"`
 }
 
+// rMapEntry represents a record of the worksheet_map_entries table.
+type rMapEntry struct {
+	Id          int64          `db:"id"`
+	MapId       string         `db:"map_id"`
+	Rank        int            `db:"rank"`
+	Key         string         `db:"key"`
+	FromVersion int            `db:"from_version"`
+	ToVersion   int            `db:"to_version"`
+	Value       dat.NullString `db:"value"`
+}
+
 var tableToEntities = map[string]interface{}{
 	"worksheets":               &rWorksheet{},
 	"worksheet_values":         &rValue{},
 	"worksheet_slice_elements": &rSliceElement{},
+	"worksheet_map_entries":    &rMapEntry{},
 }
 
 func (s *Session) Load(id string) (*Worksheet, error) {
@@ -99,6 +111,7 @@ func (s *Session) Load(id string) (*Worksheet, error) {
 		s:               s,
 		graph:           make(map[string]*Worksheet),
 		slicesToHydrate: make(map[string]*slice),
+		mapsToHydrate:   make(map[string]*mapValue),
 	}
 	return loader.loadWorksheet(id)
 }
@@ -131,6 +144,7 @@ type loader struct {
 	s               *Session
 	graph           map[string]*Worksheet
 	slicesToHydrate map[string]*slice
+	mapsToHydrate   map[string]*mapValue
 }
 
 func (l *loader) loadWorksheet(id string) (*Worksheet, error) {
@@ -190,10 +204,50 @@ func (l *loader) loadWorksheet(id string) (*Worksheet, error) {
 	}
 
 	for {
-		slicesToHydrate := l.nextSlicesToHydrate()
-		if len(slicesToHydrate) == 0 {
+		var (
+			mapsToHydrate   = l.nextMapsToHydrate()
+			slicesToHydrate = l.nextSlicesToHydrate()
+		)
+		if len(mapsToHydrate) == 0 && len(slicesToHydrate) == 0 {
 			break
 		}
+
+		if len(mapsToHydrate) != 0 {
+			mapsIds := make([]interface{}, 0, len(mapsToHydrate))
+			for _, m := range mapsToHydrate {
+				mapsIds = append(mapsIds, m.id)
+			}
+			var mapEntriesRecs []rMapEntry
+			err = l.s.tx.
+				Select("*").
+				From("worksheet_map_entries").
+				Where(inClause("map_id", len(mapsIds)), mapsIds...).
+				Where("from_version <= $1 and $1 <= to_version", wsRec.Version).
+				OrderBy("map_id, rank").
+				QueryStructs(&mapEntriesRecs)
+			if err != nil {
+				return nil, err
+			}
+			for _, mapEntryRec := range mapEntriesRecs {
+				m := mapsToHydrate[mapEntryRec.MapId]
+				value, err := l.readValue(m.typ.elementType, mapEntryRec.Value)
+				if err != nil {
+					return nil, err
+				}
+				// worksheets loaded from maps have their key frozen
+				element := value.(*Worksheet)
+				element.frozen = true
+				m.entries = append(m.entries, mapEntry{
+					rank:  mapEntryRec.Rank,
+					key:   mapEntryRec.Key,
+					value: element,
+				})
+			}
+		}
+
+		if len(slicesToHydrate) == 0 {
+			continue
+		}
 		slicesIds := make([]interface{}, len(slicesToHydrate))
 		for _, slice := range slicesToHydrate {
 			slicesIds = append(slicesIds, slice.id)
@@ -222,6 +276,10 @@ func (l *loader) loadWorksheet(id string) (*Worksheet, error) {
 		}
 	}
 
+	// Referenced worksheets track ws as their parent, such that edits made
+	// to them after loading propagate to ws.
+	ws.relink(nil, ws.data)
+
 	return ws, nil
 }
 
@@ -232,8 +290,12 @@ func (l *loader) readValue(typ Type, optValue dat.NullString) (Value, error) {
 
 	value := optValue.String
 	switch t := typ.(type) {
-	case *tTextType:
+	case *tTextType, *EnumType:
 		return NewText(value), nil
+	case *tDateType:
+		return newDateFromString(value)
+	case *tTimeType:
+		return newTimeFromString(value)
 	case *SliceType:
 		if !strings.HasPrefix(value, "[:") {
 			return nil, fmt.Errorf("unreadable value for slice %s", value)
@@ -249,7 +311,22 @@ func (l *loader) readValue(typ Type, optValue dat.NullString) (Value, error) {
 		slice := newSliceWithIdAndLastRank(t, parts[2], lastRank)
 		l.slicesToHydrate[slice.id] = slice
 		return slice, nil
-	case *Definition:
+	case *MapType:
+		if !strings.HasPrefix(value, "{:") {
+			return nil, fmt.Errorf("unreadable value for map %s", value)
+		}
+		parts := strings.Split(value, ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("unreadable value for map %s", value)
+		}
+		lastRank, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("unreadable value for map %s", value)
+		}
+		m := newMapWithIdAndLastRank(t, parts[2], lastRank)
+		l.mapsToHydrate[m.id] = m
+		return m, nil
+	case *Definition, *View:
 		if !strings.HasPrefix(value, "*:") {
 			return nil, fmt.Errorf("unreadable value for ref %s", value)
 		}
@@ -267,6 +344,12 @@ func (l *loader) readValue(typ Type, optValue dat.NullString) (Value, error) {
 	}
 }
 
+func (l *loader) nextMapsToHydrate() map[string]*mapValue {
+	mapsToHydrate := l.mapsToHydrate
+	l.mapsToHydrate = make(map[string]*mapValue)
+	return mapsToHydrate
+}
+
 func (l *loader) nextSlicesToHydrate() map[string]*slice {
 	slicesToHydrate := l.slicesToHydrate
 	l.slicesToHydrate = make(map[string]*slice)
@@ -326,7 +409,10 @@ func (p *persister) save(ws *Worksheet) error {
 	}
 
 	// insert rValues
-	var slicesToInsert []*slice
+	var (
+		slicesToInsert []*slice
+		mapsToInsert   []*mapValue
+	)
 	insertValues := p.s.tx.InsertInto("worksheet_values").Columns("*").Blacklist("id")
 	for index, value := range ws.data {
 		insertValues.Record(rValue{
@@ -337,8 +423,11 @@ func (p *persister) save(ws *Worksheet) error {
 			Value:       p.writeValue(value),
 		})
 
-		if slice, ok := value.(*slice); ok {
-			slicesToInsert = append(slicesToInsert, slice)
+		switch v := value.(type) {
+		case *slice:
+			slicesToInsert = append(slicesToInsert, v)
+		case *mapValue:
+			mapsToInsert = append(mapsToInsert, v)
 		}
 	}
 	if _, err := insertValues.Exec(); err != nil {
@@ -363,6 +452,29 @@ func (p *persister) save(ws *Worksheet) error {
 		}
 	}
 
+	if len(mapsToInsert) != 0 {
+		var hasEntries bool
+		insertMapEntries := p.s.tx.InsertInto("worksheet_map_entries").Columns("*").Blacklist("id")
+		for _, m := range mapsToInsert {
+			for _, entry := range m.entries {
+				hasEntries = true
+				insertMapEntries.Record(rMapEntry{
+					MapId:       m.id,
+					Rank:        entry.rank,
+					Key:         entry.key,
+					FromVersion: ws.Version(),
+					ToVersion:   math.MaxInt32,
+					Value:       p.writeValue(entry.value),
+				})
+			}
+		}
+		if hasEntries {
+			if _, err := insertMapEntries.Exec(); err != nil {
+				return err
+			}
+		}
+	}
+
 	// now we can update ws itself to reflect the save
 	for index, value := range ws.data {
 		ws.orig[index] = value
@@ -410,6 +522,8 @@ func (p *persister) update(ws *Worksheet) error {
 		valuesToUpdate      = make([]int, 0, len(diff))
 		slicesRanksOfDels   = make(map[string][]int)
 		slicesElementsAdded = make(map[string][]sliceElement)
+		mapsRanksOfDels     = make(map[string][]int)
+		mapsEntriesAdded    = make(map[string][]mapEntry)
 	)
 	for index, change := range diff {
 		valuesToUpdate = append(valuesToUpdate, index)
@@ -428,6 +542,23 @@ func (p *persister) update(ws *Worksheet) error {
 				}
 			}
 		}
+		if mapBefore, ok := change.before.(*mapValue); ok {
+			if mapAfter, ok := change.after.(*mapValue); ok {
+				if mapBefore.id == mapAfter.id {
+					ranksOfDels, entriesAdded := diffMaps(mapBefore, mapAfter)
+
+					mapId := mapBefore.id
+					if len(ranksOfDels) != 0 {
+						mapsRanksOfDels[mapId] = ranksOfDels
+					}
+					if len(entriesAdded) != 0 {
+						mapsEntriesAdded[mapId] = entriesAdded
+					}
+				}
+			}
+		} else if mapAfter, ok := change.after.(*mapValue); ok {
+			mapsEntriesAdded[mapAfter.id] = mapAfter.entries
+		}
 	}
 
 	// update old rValues
@@ -487,6 +618,37 @@ func (p *persister) update(ws *Worksheet) error {
 		}
 	}
 
+	// maps: deleted entries
+	for mapId, ranks := range mapsRanksOfDels {
+		if _, err := p.s.tx.
+			Update("worksheet_map_entries").
+			Set("to_version", oldVersion).
+			Where("map_id = $1", mapId).
+			Where("from_version <= $1 and $1 <= to_version", oldVersion).
+			Where(inClause("rank", len(ranks)), ughconvert(ranks)...).
+			Exec(); err != nil {
+			return err
+		}
+	}
+
+	// maps: added entries
+	for mapId, adds := range mapsEntriesAdded {
+		insert := p.s.tx.InsertInto("worksheet_map_entries").Columns("*").Blacklist("id")
+		for _, add := range adds {
+			insert.Record(rMapEntry{
+				MapId:       mapId,
+				FromVersion: newVersion,
+				ToVersion:   math.MaxInt32,
+				Rank:        add.rank,
+				Key:         add.key,
+				Value:       p.writeValue(add.value),
+			})
+		}
+		if _, err := insert.Exec(); err != nil {
+			return err
+		}
+	}
+
 	// update rWorksheet
 	if result, err := p.s.tx.
 		Update("worksheets").
@@ -504,6 +666,11 @@ func (p *persister) update(ws *Worksheet) error {
 		ws.orig[index] = value
 	}
 
+	// parents may depend on the version
+	if err := ws.propagate(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -516,8 +683,14 @@ func (p *persister) writeValue(value Value) dat.NullString {
 	switch v := value.(type) {
 	case *Text:
 		result = v.value
+	case *Date:
+		result = v.value.Format(dateLayout)
+	case *Time:
+		result = v.value.Format(timeLayout)
 	case *slice:
 		result = fmt.Sprintf("[:%d:%s", v.lastRank, v.id)
+	case *mapValue:
+		result = fmt.Sprintf("{:%d:%s", v.lastRank, v.id)
 	case *Worksheet:
 		result = fmt.Sprintf("*:%s", v.Id())
 	default:
@@ -552,6 +725,12 @@ func worksheetsToCascade(value Value) []*Worksheet {
 			result = append(result, worksheetsToCascade(element.value)...)
 		}
 		return result
+	case *mapValue:
+		var result []*Worksheet
+		for _, entry := range v.entries {
+			result = append(result, entry.value)
+		}
+		return result
 	default:
 		return nil
 	}
@@ -14,6 +14,7 @@ package worksheets
 
 import (
 	"math"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"gopkg.in/mgutz/dat.v2/sqlx-runner"
@@ -298,6 +299,97 @@ func (s *DbZuite) TestUpdateOnUpdateDoesNothing() {
 	require.Equal(s.T(), 2, ws.Version())
 }
 
+func (s *DbZuite) TestDateAndTime_saveLoad() {
+	var (
+		birthday = NewDate(1930, time.August, 5)
+		landedAt = NewTime(time.Date(1969, time.July, 20, 20, 17, 40, 0, time.UTC))
+	)
+
+	ws := defs.MustNewWorksheet("with_dates")
+	ws.MustSet("birthday", birthday)
+	ws.MustSet("landed_at", landedAt)
+
+	s.MustRunTransaction(func(tx *runner.Tx) error {
+		session := s.store.Open(tx)
+		return session.Save(ws)
+	})
+
+	// Dates and times are stored in their ISO 8601 representation.
+	_, valuesRecs, _ := s.DbState()
+	require.Equal(s.T(), []rValueForTesting{
+		{
+			WorksheetId: ws.Id(),
+			Index:       IndexId,
+			FromVersion: 1,
+			ToVersion:   math.MaxInt32,
+			Value:       ws.Id(),
+		},
+		{
+			WorksheetId: ws.Id(),
+			Index:       IndexVersion,
+			FromVersion: 1,
+			ToVersion:   math.MaxInt32,
+			Value:       `1`,
+		},
+		{
+			WorksheetId: ws.Id(),
+			Index:       5,
+			FromVersion: 1,
+			ToVersion:   math.MaxInt32,
+			Value:       `1930-08-05`,
+		},
+		{
+			WorksheetId: ws.Id(),
+			Index:       8,
+			FromVersion: 1,
+			ToVersion:   math.MaxInt32,
+			Value:       `1969-07-20T20:17:40Z`,
+		},
+	}, valuesRecs)
+
+	var fresh *Worksheet
+	s.MustRunTransaction(func(tx *runner.Tx) error {
+		session := s.store.Open(tx)
+		var err error
+		fresh, err = session.Load(ws.Id())
+		return err
+	})
+
+	require.Equal(s.T(), birthday, fresh.MustGet("birthday"))
+	require.Equal(s.T(), landedAt, fresh.MustGet("landed_at"))
+}
+
+func (s *DbZuite) TestTuple_saveLoad() {
+	pair := MustNewTuple(NewText("Alice"), MustNewValue("4.25"))
+
+	ws := defs.MustNewWorksheet("with_tuple")
+	ws.MustSet("pair", pair)
+
+	s.MustRunTransaction(func(tx *runner.Tx) error {
+		session := s.store.Open(tx)
+		return session.Save(ws)
+	})
+
+	_, valuesRecs, _ := s.DbState()
+	require.Contains(s.T(), valuesRecs, rValueForTesting{
+		WorksheetId: ws.Id(),
+		Index:       3,
+		FromVersion: 1,
+		ToVersion:   math.MaxInt32,
+		Value:       `tuple("Alice", 4.25)`,
+	})
+
+	var fresh *Worksheet
+	s.MustRunTransaction(func(tx *runner.Tx) error {
+		session := s.store.Open(tx)
+		var err error
+		fresh, err = session.Load(ws.Id())
+		return err
+	})
+
+	require.Equal(s.T(), pair, fresh.MustGet("pair"))
+}
+
 func (s *DbZuite) MustRunTransaction(fn func(tx *runner.Tx) error) {
 	err := RunTransaction(s.db, fn)
 	require.NoError(s.T(), err)
@@ -0,0 +1,699 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Edit is a proposed edit block, i.e. a set of individual edits to inputs of
+// a worksheet. Proposing edits does not touch any worksheet, edits are only
+// checked when computing the actual edit, or applying it.
+//
+// A field is set or unset at most once per edit block, proposing to set a
+// field again replaces the earlier proposal.
+type Edit struct {
+	ops []editOp
+}
+
+type editOp interface {
+	// fieldName is the name of the field this individual edit touches.
+	fieldName() string
+
+	// apply applies this individual edit to the worksheet. Computed fields
+	// are not recomputed, see recompute.
+	apply(ws *Worksheet) error
+}
+
+type editSet struct {
+	name  string
+	value Value
+}
+
+type editUnset struct {
+	name string
+}
+
+type editAppend struct {
+	name    string
+	element Value
+}
+
+type editDel struct {
+	name  string
+	index int
+}
+
+type editPut struct {
+	name  string
+	value Value
+}
+
+type editDelKey struct {
+	name string
+	key  []Value
+}
+
+// NewEdit creates an empty edit block.
+func NewEdit() *Edit {
+	return &Edit{}
+}
+
+// Set proposes to set field name to value.
+func (e *Edit) Set(name string, value Value) *Edit {
+	e.replaceOrAdd(&editSet{name, value})
+	return e
+}
+
+// Unset proposes to unset field name.
+func (e *Edit) Unset(name string) *Edit {
+	e.replaceOrAdd(&editUnset{name})
+	return e
+}
+
+// Append proposes to append element to the slice field name.
+func (e *Edit) Append(name string, element Value) *Edit {
+	e.ops = append(e.ops, &editAppend{name, element})
+	return e
+}
+
+// Del proposes to delete the element at index of the slice field name.
+func (e *Edit) Del(name string, index int) *Edit {
+	e.ops = append(e.ops, &editDel{name, index})
+	return e
+}
+
+// Put proposes to put the worksheet value in the map field name.
+func (e *Edit) Put(name string, value Value) *Edit {
+	e.ops = append(e.ops, &editPut{name, value})
+	return e
+}
+
+// DelKey proposes to delete the worksheet with the given key from the map
+// field name.
+func (e *Edit) DelKey(name string, key ...Value) *Edit {
+	e.ops = append(e.ops, &editDelKey{name, key})
+	return e
+}
+
+// IsSetting reports whether this edit block proposes any individual edit
+// to field name.
+func (e *Edit) IsSetting(name string) bool {
+	for _, op := range e.ops {
+		if op.fieldName() == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Edit) copy() *Edit {
+	return &Edit{
+		ops: append([]editOp(nil), e.ops...),
+	}
+}
+
+func (e *Edit) replaceOrAdd(op editOp) {
+	for i, existing := range e.ops {
+		switch existing.(type) {
+		case *editSet, *editUnset:
+			if existing.fieldName() == op.fieldName() {
+				e.ops[i] = op
+				return
+			}
+		}
+	}
+	e.ops = append(e.ops, op)
+}
+
+func (op *editSet) fieldName() string {
+	return op.name
+}
+
+func (op *editSet) apply(ws *Worksheet) error {
+	// lookup field by name
+	field, ok := ws.def.fieldsByName[op.name]
+	if !ok {
+		return fmt.Errorf("unknown field %s", op.name)
+	}
+
+	if field.computedBy != nil {
+		return fmt.Errorf("cannot assign to computed field %s", op.name)
+	}
+
+	if _, ok := field.typ.(*SliceType); ok {
+		return fmt.Errorf("Set on slice field %s, use Append, or Del", op.name)
+	}
+
+	if _, ok := field.typ.(*MapType); ok {
+		return fmt.Errorf("Set on map field %s, use Put, or DelKey", op.name)
+	}
+
+	if ws.frozen && ws.def.frozenFields[field.index] {
+		return fmt.Errorf("cannot assign to frozen field %s", op.name)
+	}
+
+	return ws.set(field, op.value)
+}
+
+func (op *editUnset) fieldName() string {
+	return op.name
+}
+
+func (op *editUnset) apply(ws *Worksheet) error {
+	if field, ok := ws.def.fieldsByName[op.name]; ok {
+		if _, ok := field.typ.(*SliceType); ok {
+			return fmt.Errorf("Unset on slice field names, must use Del")
+		}
+		if _, ok := field.typ.(*MapType); ok {
+			return fmt.Errorf("Unset on map field %s, must use DelKey", op.name)
+		}
+	}
+	return (&editSet{op.name, NewUndefined()}).apply(ws)
+}
+
+func (op *editAppend) fieldName() string {
+	return op.name
+}
+
+func (op *editAppend) apply(ws *Worksheet) error {
+	// lookup field by name
+	field, ok := ws.def.fieldsByName[op.name]
+	if !ok {
+		return fmt.Errorf("unknown field %s", op.name)
+	}
+
+	sliceType, ok := field.typ.(*SliceType)
+	if !ok {
+		return fmt.Errorf("Append on non-slice field %s", op.name)
+	}
+
+	// is a value set for this field?
+	value, ok := ws.data[field.index]
+	if !ok {
+		value = newSlice(sliceType)
+	}
+
+	// append
+	slice, err := value.(*slice).doAppend(op.element)
+	if err != nil {
+		return err
+	}
+
+	return ws.set(field, slice)
+}
+
+func (op *editDel) fieldName() string {
+	return op.name
+}
+
+func (op *editDel) apply(ws *Worksheet) error {
+	field, slice, err := ws.getSlice(op.name)
+	if err != nil {
+		if field != nil {
+			if _, ok := field.typ.(*SliceType); !ok {
+				return fmt.Errorf("Del on non-slice field %s", op.name)
+			}
+		}
+		return err
+	}
+
+	slice, err = slice.doDel(op.index)
+	if err != nil {
+		return err
+	}
+
+	return ws.set(field, slice)
+}
+
+func (op *editPut) fieldName() string {
+	return op.name
+}
+
+func (op *editPut) apply(ws *Worksheet) error {
+	// lookup field by name
+	field, ok := ws.def.fieldsByName[op.name]
+	if !ok {
+		return fmt.Errorf("unknown field %s", op.name)
+	}
+
+	mapType, ok := field.typ.(*MapType)
+	if !ok {
+		return fmt.Errorf("Put on non-map field %s", op.name)
+	}
+
+	element, ok := op.value.(*Worksheet)
+	if !ok || !element.Type().AssignableTo(mapType.elementType) {
+		return fmt.Errorf("cannot put %s in %s", op.value.Type(), mapType)
+	}
+
+	key, err := element.key()
+	if err != nil {
+		return err
+	}
+
+	// is a value set for this field?
+	value, ok := ws.data[field.index]
+	if !ok {
+		value = newMap(mapType)
+	}
+
+	m, err := value.(*mapValue).doPut(key, element)
+	if err != nil {
+		return err
+	}
+
+	return ws.set(field, m)
+}
+
+func (op *editDelKey) fieldName() string {
+	return op.name
+}
+
+func (op *editDelKey) apply(ws *Worksheet) error {
+	field, m, err := ws.getMap(op.name)
+	if err != nil {
+		if field != nil {
+			if _, ok := field.typ.(*MapType); !ok {
+				return fmt.Errorf("DelKey on non-map field %s", op.name)
+			}
+		}
+		return err
+	}
+
+	key, err := field.typ.(*MapType).elementType.(*Definition).keyOf(op.key)
+	if err != nil {
+		return err
+	}
+
+	m, err = m.doDelKey(key)
+	if err != nil {
+		return err
+	}
+
+	return ws.set(field, m)
+}
+
+// ActualEdit is the result of applying a proposed edit block to a worksheet,
+// i.e. the individual edits proposed, and all changes to computed fields they
+// caused.
+type ActualEdit struct {
+	// ws is the worksheet this edit is on.
+	ws *Worksheet
+
+	// before and after hold the worksheet data before and after the edit.
+	before, after map[int]Value
+}
+
+// IsSetting reports whether field name is modified by this edit.
+func (e *ActualEdit) IsSetting(name string) bool {
+	field, ok := e.ws.def.fieldsByName[name]
+	if !ok {
+		return false
+	}
+	_, changed := e.changes()[field.index]
+	return changed
+}
+
+// Fields returns the names of all fields modified by this edit, in
+// alphabetical order.
+func (e *ActualEdit) Fields() []string {
+	var names []string
+	for index := range e.changes() {
+		names = append(names, e.ws.def.fieldsByIndex[index].name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (e *ActualEdit) MustBefore(name string) Value {
+	value, err := e.Before(name)
+	if err != nil {
+		panic(err)
+	}
+	return value
+}
+
+// Before returns the value of field name before this edit.
+func (e *ActualEdit) Before(name string) (Value, error) {
+	return e.valueOf(e.before, name)
+}
+
+func (e *ActualEdit) MustAfter(name string) Value {
+	value, err := e.After(name)
+	if err != nil {
+		panic(err)
+	}
+	return value
+}
+
+// After returns the value of field name after this edit.
+func (e *ActualEdit) After(name string) (Value, error) {
+	return e.valueOf(e.after, name)
+}
+
+func (e *ActualEdit) valueOf(data map[int]Value, name string) (Value, error) {
+	field, ok := e.ws.def.fieldsByName[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown field %s", name)
+	}
+	value, ok := data[field.index]
+	if !ok {
+		return &Undefined{}, nil
+	}
+	return value, nil
+}
+
+func (e *ActualEdit) changes() map[int]change {
+	return diffData(e.before, e.after)
+}
+
+// ErrUnstableEdit is returned when computing an edit does not reach a fixed
+// point, i.e. reactors and computed fields keep bringing the worksheet back to
+// a state it was in earlier in the calculation, or keep bringing it to new
+// states for more than maxReactorRounds rounds.
+var ErrUnstableEdit = errors.New("unstable edit")
+
+// maxReactorRounds bounds the number of times reactors are called when
+// computing an edit.
+const maxReactorRounds = 100
+
+// ComputeEdit determines the actual edit resulting from the proposed edit
+// block, without modifying the worksheet. All computed fields affected by the
+// proposed edits are re-computed, and reactors are called, until we reach a
+// fixed point. Finally, all affected constraints are verified.
+func (ws *Worksheet) ComputeEdit(proposed *Edit) (*ActualEdit, error) {
+	var (
+		edit   = proposed.copy()
+		states []map[int]Value
+	)
+	for round := 0; ; round++ {
+		tentative, err := ws.tentativelyApply(edit)
+		if err != nil {
+			return nil, err
+		}
+
+		// We detect cycles by comparing worksheet states rather than edits,
+		// since two different edits can yield the same state.
+		for i, state := range states {
+			if sameData(state, tentative.data) {
+				if i != len(states)-1 {
+					return nil, ErrUnstableEdit
+				}
+				return ws.actualEdit(tentative)
+			}
+		}
+		states = append(states, tentative.data)
+
+		if len(ws.def.reactors) == 0 {
+			return ws.actualEdit(tentative)
+		}
+		if round == maxReactorRounds {
+			return nil, ErrUnstableEdit
+		}
+		for _, reactor := range ws.def.reactors {
+			edit, err = reactor.OnEdit(tentative, edit)
+			if err != nil {
+				return nil, err
+			}
+			if edit == nil {
+				return nil, fmt.Errorf("reactor %T returned no edit", reactor)
+			}
+		}
+	}
+}
+
+// tentativelyApply applies an edit on a copy of the worksheet, such that
+// failures at any step leave the worksheet untouched.
+func (ws *Worksheet) tentativelyApply(edit *Edit) (*Worksheet, error) {
+	tentative := &Worksheet{
+		def:    ws.def,
+		orig:   ws.orig,
+		data:   make(map[int]Value, len(ws.data)),
+		frozen: ws.frozen,
+	}
+	for index, value := range ws.data {
+		tentative.data[index] = value
+	}
+
+	for _, op := range edit.ops {
+		if err := op.apply(tentative); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tentative.recompute(ws.data); err != nil {
+		return nil, err
+	}
+
+	return tentative, nil
+}
+
+func (ws *Worksheet) actualEdit(tentative *Worksheet) (*ActualEdit, error) {
+	if err := tentative.checkConstraints(ws.data); err != nil {
+		return nil, err
+	}
+
+	return &ActualEdit{
+		ws:     ws,
+		before: ws.data,
+		after:  tentative.data,
+	}, nil
+}
+
+// sameData reports whether two worksheet states are the same. Slices and maps
+// are compared element by element, since re-applying an edit yields new ones.
+func sameData(left, right map[int]Value) bool {
+	if len(left) != len(right) {
+		return false
+	}
+	for index, l := range left {
+		r, ok := right[index]
+		if !ok || !sameValue(l, r) {
+			return false
+		}
+	}
+	return true
+}
+
+func sameValue(left, right Value) bool {
+	if lMap, ok := left.(*mapValue); ok {
+		rMap, ok := right.(*mapValue)
+		if !ok || len(lMap.entries) != len(rMap.entries) {
+			return false
+		}
+		for i := range lMap.entries {
+			if lMap.entries[i] != rMap.entries[i] {
+				return false
+			}
+		}
+		return true
+	}
+
+	lSlice, ok := left.(*slice)
+	if !ok {
+		return left.Equal(right)
+	}
+	rSlice, ok := right.(*slice)
+	if !ok || len(lSlice.elements) != len(rSlice.elements) {
+		return false
+	}
+	for i := range lSlice.elements {
+		l, r := lSlice.elements[i], rSlice.elements[i]
+		if l.rank != r.rank || !sameValue(l.value, r.value) {
+			return false
+		}
+	}
+	return true
+}
+
+func (ws *Worksheet) MustApply(proposed *Edit) *ActualEdit {
+	actual, err := ws.Apply(proposed)
+	if err != nil {
+		panic(err)
+	}
+	return actual
+}
+
+// Apply applies the proposed edit block atomically: either all individual
+// edits, and their effects on computed fields, are applied to the worksheet,
+// or none are.
+func (ws *Worksheet) Apply(proposed *Edit) (*ActualEdit, error) {
+	actual, err := ws.ComputeEdit(proposed)
+	if err != nil {
+		return nil, err
+	}
+
+	ws.data = actual.after
+	if err := ws.propagate(); err != nil {
+		ws.data = actual.before
+		return nil, err
+	}
+	ws.relink(actual.before, actual.after)
+
+	// Worksheets in maps have their key frozen, until removed from all maps.
+	for _, change := range actual.changes() {
+		for _, value := range []Value{change.before, change.after} {
+			if m, ok := value.(*mapValue); ok {
+				for _, entry := range m.entries {
+					entry.value.frozen = entry.value.inMap()
+				}
+			}
+		}
+	}
+
+	return actual, nil
+}
+
+// propagate recomputes the computed fields of the parents of the worksheet,
+// after its data changed, and so on up the graph of references. Unchanged
+// parents are walked through, since their own parents may select through
+// them, e.g. `b.c.v` in a worksheet referencing b, which references c. Either
+// all parents are recomputed, and satisfy their constraints, or none are.
+func (ws *Worksheet) propagate() error {
+	var (
+		queue  = []*Worksheet{ws}
+		states = make(map[*Worksheet][]map[int]Value)
+		err    error
+
+		// visited holds the worksheets queued since the last change, such
+		// that walking through cycles of unchanged worksheets terminates.
+		visited = map[*Worksheet]bool{ws: true}
+	)
+	for len(queue) != 0 && err == nil {
+		child := queue[0]
+		queue = queue[1:]
+		for parent, indexes := range child.parents {
+			var changed bool
+			changed, err = parent.recomputeReferences(indexes, states)
+			if err != nil {
+				break
+			}
+			if !changed {
+				if !visited[parent] {
+					visited[parent] = true
+					queue = append(queue, parent)
+				}
+				continue
+			}
+			// Computed fields of the parent may feed its constraints.
+			if err = parent.checkConstraints(states[parent][0]); err != nil {
+				break
+			}
+			visited = map[*Worksheet]bool{parent: true}
+			queue = append(queue, parent)
+		}
+	}
+
+	if err != nil {
+		for parent, parentStates := range states {
+			parent.data = parentStates[0]
+		}
+		return err
+	}
+	for parent, parentStates := range states {
+		parent.relink(parentStates[0], parent.data)
+	}
+	return nil
+}
+
+// recomputeReferences recomputes the computed fields of the worksheet which
+// depend on the reference fields at indexes, and reports whether its data
+// changed. All states the worksheet goes through are recorded in states, so
+// that we can detect cycles, and restore the worksheet.
+func (ws *Worksheet) recomputeReferences(indexes map[int]int, states map[*Worksheet][]map[int]Value) (bool, error) {
+	tentative := &Worksheet{
+		def:    ws.def,
+		orig:   ws.orig,
+		data:   make(map[int]Value, len(ws.data)),
+		frozen: ws.frozen,
+	}
+	for index, value := range ws.data {
+		tentative.data[index] = value
+	}
+
+	changed := make(map[int]bool)
+	for index := range indexes {
+		changed[index] = true
+	}
+	if err := tentative.recomputeChanged(changed); err != nil {
+		return false, err
+	}
+	if sameData(ws.data, tentative.data) {
+		return false, nil
+	}
+
+	if _, ok := states[ws]; !ok {
+		states[ws] = []map[int]Value{ws.data}
+	}
+	for _, state := range states[ws] {
+		if sameData(state, tentative.data) {
+			return false, ErrUnstableEdit
+		}
+	}
+	states[ws] = append(states[ws], tentative.data)
+	ws.data = tentative.data
+	return true, nil
+}
+
+// relink updates the parents of the worksheets referenced by this worksheet,
+// be it directly or as elements of slices and maps, following a change of its
+// data from before to after.
+func (ws *Worksheet) relink(before, after map[int]Value) {
+	for index, change := range diffData(before, after) {
+		for _, child := range worksheetsToCascade(change.before) {
+			child.removeParent(ws, index)
+		}
+		for _, child := range worksheetsToCascade(change.after) {
+			child.addParent(ws, index)
+		}
+	}
+}
+
+// inMap reports whether the worksheet is an entry of a map of any of its
+// parents.
+func (ws *Worksheet) inMap() bool {
+	for parent, indexes := range ws.parents {
+		for index := range indexes {
+			if _, ok := parent.def.fieldsByIndex[index].typ.(*MapType); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (ws *Worksheet) addParent(parent *Worksheet, index int) {
+	if ws.parents == nil {
+		ws.parents = make(map[*Worksheet]map[int]int)
+	}
+	if ws.parents[parent] == nil {
+		ws.parents[parent] = make(map[int]int)
+	}
+	ws.parents[parent][index]++
+}
+
+func (ws *Worksheet) removeParent(parent *Worksheet, index int) {
+	indexes, ok := ws.parents[parent]
+	if !ok {
+		return
+	}
+	if indexes[index]--; indexes[index] <= 0 {
+		delete(indexes, index)
+	}
+	if len(indexes) == 0 {
+		delete(ws.parents, parent)
+	}
+}
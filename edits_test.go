@@ -0,0 +1,276 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/stretchr/testify/require"
+)
+
+var editsDefs = MustNewDefinitions(strings.NewReader(`
+worksheet edits {
+	1:name text
+	2:age number[0]
+	3:age_plus_one number[0] computed_by {
+		return age + 1
+	}
+	4:age_plus_two number[0] computed_by {
+		return age_plus_one + 1
+	}
+	5:names []text
+}`))
+
+func (s *Zuite) TestEdit_proposed() {
+	edit := NewEdit().
+		Set("name", alice).
+		Set("name", bob).
+		Unset("age")
+
+	require.True(s.T(), edit.IsSetting("name"))
+	require.True(s.T(), edit.IsSetting("age"))
+	require.False(s.T(), edit.IsSetting("names"))
+
+	// setting a field again replaces the earlier proposal
+	require.Equal(s.T(), []editOp{
+		&editSet{"name", bob},
+		&editUnset{"age"},
+	}, edit.ops)
+}
+
+func (s *Zuite) TestEdit_computeDoesNotModifyWorksheet() {
+	ws := editsDefs.MustNewWorksheet("edits")
+
+	actual, err := ws.ComputeEdit(NewEdit().Set("age", MustNewValue("5")))
+	require.NoError(s.T(), err)
+
+	require.Equal(s.T(), []string{"age", "age_plus_one", "age_plus_two"}, actual.Fields())
+	require.True(s.T(), actual.IsSetting("age_plus_two"))
+	require.False(s.T(), actual.IsSetting("name"))
+	require.False(s.T(), actual.IsSetting("unknown_field"))
+	require.Equal(s.T(), &Undefined{}, actual.MustBefore("age_plus_two"))
+	require.Equal(s.T(), "7", actual.MustAfter("age_plus_two").String())
+
+	_, err = actual.Before("unknown_field")
+	require.EqualError(s.T(), err, "unknown field unknown_field")
+
+	require.False(s.T(), ws.MustIsSet("age"))
+	require.False(s.T(), ws.MustIsSet("age_plus_two"))
+}
+
+func (s *Zuite) TestEdit_apply() {
+	ws := editsDefs.MustNewWorksheet("edits")
+	ws.MustSet("age", MustNewValue("5"))
+
+	actual := ws.MustApply(NewEdit().
+		Set("name", alice).
+		Set("age", MustNewValue("6")).
+		Append("names", alice).
+		Append("names", bob))
+
+	require.Equal(s.T(), []string{"age", "age_plus_one", "age_plus_two", "name", "names"}, actual.Fields())
+	require.Equal(s.T(), "6", actual.MustBefore("age_plus_one").String())
+	require.Equal(s.T(), "7", actual.MustAfter("age_plus_one").String())
+
+	require.Equal(s.T(), alice, ws.MustGet("name"))
+	require.Equal(s.T(), "8", ws.MustGet("age_plus_two").String())
+	require.Equal(s.T(), []Value{alice, bob}, ws.MustGetSlice("names"))
+
+	// an edit which does not change anything
+	actual = ws.MustApply(NewEdit().Set("name", alice))
+	require.Empty(s.T(), actual.Fields())
+}
+
+func (s *Zuite) TestEdit_applyIsAtomic() {
+	ws := editsDefs.MustNewWorksheet("edits")
+	ws.MustAppend("names", alice)
+
+	_, err := ws.Apply(NewEdit().
+		Set("name", alice).
+		Set("age", MustNewValue("5")).
+		Append("names", bob).
+		Del("names", 7))
+	require.EqualError(s.T(), err, "index out of range")
+
+	require.False(s.T(), ws.MustIsSet("name"))
+	require.False(s.T(), ws.MustIsSet("age"))
+	require.False(s.T(), ws.MustIsSet("age_plus_one"))
+	require.Equal(s.T(), []Value{alice}, ws.MustGetSlice("names"))
+
+	// the failed append left no trace on the slice's ranks
+	ws.MustAppend("names", carol)
+	require.Equal(s.T(), []sliceElement{
+		{1, alice},
+		{2, carol},
+	}, ws.data[5].(*slice).elements)
+}
+
+func (s *Zuite) TestEdit_errors() {
+	cases := map[string]*Edit{
+		`unknown field unknown_field`:                                 NewEdit().Set("unknown_field", alice),
+		`cannot assign to computed field age_plus_one`:                NewEdit().Set("age_plus_one", MustNewValue("1")),
+		`Set on slice field names, use Append, or Del`:                NewEdit().Set("names", alice),
+		`Append on non-slice field name`:                              NewEdit().Append("name", alice),
+		`Del on non-slice field name`:                                 NewEdit().Del("name", 0),
+		`cannot append number[0] to []text`:                           NewEdit().Append("names", MustNewValue("1")),
+		`cannot assign value of type text to field of type number[0]`: NewEdit().Set("age", alice),
+	}
+	for msg, edit := range cases {
+		ws := editsDefs.MustNewWorksheet("edits")
+		_, err := ws.ComputeEdit(edit)
+		require.EqualError(s.T(), err, msg)
+	}
+}
+
+type flipper struct{}
+
+func (flipper) OnEdit(current *Worksheet, proposed *Edit) (*Edit, error) {
+	wrong := current.MustGet("wrong")
+	return proposed.Set("right", wrong), nil
+}
+
+func (s *Zuite) TestEdit_unstableEditsAreRejected() {
+	defs := MustNewDefinitions(strings.NewReader(`worksheet cyclic_edits {
+		1:right bool
+		2:wrong bool computed_by {
+			return !right
+		}
+	}`), Options{
+		Reactors: map[string][]Reactor{
+			"cyclic_edits": []Reactor{flipper{}},
+		},
+	})
+	ws := defs.MustNewWorksheet("cyclic_edits")
+
+	_, err := ws.Apply(NewEdit().Set("right", MustNewValue("false")))
+	require.Equal(s.T(), ErrUnstableEdit, err)
+	require.False(s.T(), ws.MustIsSet("right"))
+	require.False(s.T(), ws.MustIsSet("wrong"))
+}
+
+type counter struct{}
+
+func (counter) OnEdit(current *Worksheet, proposed *Edit) (*Edit, error) {
+	count, ok := current.MustGet("count").(*Number)
+	if !ok {
+		return proposed, nil
+	}
+	return proposed.Set("count", MustNewValue(fmt.Sprintf("%d", count.value+1))), nil
+}
+
+func (s *Zuite) TestEdit_neverSettlingEditsAreRejected() {
+	defs := MustNewDefinitions(strings.NewReader(`worksheet counted {
+		1:count number[0]
+	}`), Options{
+		Reactors: map[string][]Reactor{
+			"counted": []Reactor{counter{}},
+		},
+	})
+	ws := defs.MustNewWorksheet("counted")
+
+	_, err := ws.Apply(NewEdit().Set("count", MustNewValue("0")))
+	require.Equal(s.T(), ErrUnstableEdit, err)
+	require.False(s.T(), ws.MustIsSet("count"))
+}
+
+type nilEdit struct{}
+
+func (nilEdit) OnEdit(current *Worksheet, proposed *Edit) (*Edit, error) {
+	if proposed.IsSetting("name") {
+		return nil, nil
+	}
+	return proposed, nil
+}
+
+func (s *Zuite) TestEdit_reactorReturningNoEdit() {
+	defs := MustNewDefinitions(strings.NewReader(`worksheet simple {
+		1:name text
+	}`), Options{
+		Reactors: map[string][]Reactor{
+			"simple": []Reactor{nilEdit{}},
+		},
+	})
+	ws := defs.MustNewWorksheet("simple")
+
+	err := ws.Set("name", alice)
+	require.EqualError(s.T(), err, "reactor worksheets.nilEdit returned no edit")
+	require.False(s.T(), ws.MustIsSet("name"))
+}
+
+type pizzapie struct{}
+
+func (pizzapie) OnEdit(current *Worksheet, proposed *Edit) (*Edit, error) {
+	if current.MustGet("name").Equal(NewText("Joey")) {
+		return proposed.Set("name", NewText("Joey Pizzapie")), nil
+	}
+	if current.MustGet("name").Equal(NewText("Boom")) {
+		return nil, fmt.Errorf("no booms allowed")
+	}
+	return proposed, nil
+}
+
+type greeter struct{}
+
+func (greeter) OnEdit(current *Worksheet, proposed *Edit) (*Edit, error) {
+	if proposed.IsSetting("name") && len(current.MustGetSlice("greeted")) == 0 {
+		return proposed.Append("greeted", current.MustGet("name")), nil
+	}
+	return proposed, nil
+}
+
+var reactorsDefs = MustNewDefinitions(strings.NewReader(`worksheet borrower {
+	1:name text
+	2:is_joey bool computed_by {
+		return name == "Joey"
+	}
+	3:greeted []text
+}`), Options{
+	Reactors: map[string][]Reactor{
+		"borrower": []Reactor{pizzapie{}, greeter{}},
+	},
+})
+
+func (s *Zuite) TestEdit_reactors() {
+	ws := reactorsDefs.MustNewWorksheet("borrower")
+
+	proposed := NewEdit().Set("name", NewText("Joey"))
+	actual := ws.MustApply(proposed)
+
+	require.Equal(s.T(), []string{"greeted", "is_joey", "name"}, actual.Fields())
+	require.Equal(s.T(), NewText("Joey Pizzapie"), ws.MustGet("name"))
+	require.Equal(s.T(), "false", ws.MustGet("is_joey").String())
+	require.Equal(s.T(), []Value{NewText("Joey")}, ws.MustGetSlice("greeted"))
+
+	// reactors amend a copy of the proposed edit
+	require.Equal(s.T(), []editOp{&editSet{"name", NewText("Joey")}}, proposed.ops)
+
+	// reactors only amend edits further when needed
+	ws.MustSet("name", NewText("Chandler"))
+	require.Equal(s.T(), []Value{NewText("Joey")}, ws.MustGetSlice("greeted"))
+}
+
+func (s *Zuite) TestEdit_reactorErrors() {
+	ws := reactorsDefs.MustNewWorksheet("borrower")
+
+	err := ws.Set("name", NewText("Boom"))
+	require.EqualError(s.T(), err, "no booms allowed")
+	require.False(s.T(), ws.MustIsSet("name"))
+
+	_, err = NewDefinitions(strings.NewReader(`worksheet simple {1:name text}`), Options{
+		Reactors: map[string][]Reactor{
+			"not_so_simple": []Reactor{pizzapie{}},
+		},
+	})
+	require.EqualError(s.T(), err, "reactors: unknown worksheet(not_so_simple)")
+}
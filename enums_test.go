@@ -0,0 +1,168 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"math"
+	"strings"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+var enumsDefs = MustNewDefinitions(strings.NewReader(`
+enum suffix {
+	"Jr.",
+	"Sr.",
+	"III",
+}
+
+enum state { "CA", "NY" }
+
+worksheet borrower {
+	1:last_name text
+	2:suffix suffix
+	3:is_junior bool computed_by {
+		return suffix == "Jr."
+	}
+	4:formal_suffix suffix computed_by {
+		return suffix
+	}
+	5:past_suffixes []suffix
+	6:state state
+}`))
+
+func (s *Zuite) TestEnums_parse() {
+	var (
+		borrower = enumsDefs.Definition("borrower")
+		suffix   = borrower.FieldByName("suffix")
+	)
+
+	enum, ok := suffix.Type().(*EnumType)
+	require.True(s.T(), ok)
+	require.Equal(s.T(), "suffix", enum.Name())
+	require.Equal(s.T(), "suffix", enum.String())
+	require.Equal(s.T(), []string{"Jr.", "Sr.", "III"}, enum.Values())
+	require.Equal(s.T(), []string{"Jr.", "Sr.", "III"}, suffix.EnumValues())
+	require.Equal(s.T(), []string{"CA", "NY"}, borrower.FieldByName("state").EnumValues())
+	require.Nil(s.T(), borrower.FieldByName("last_name").EnumValues())
+
+	require.Equal(s.T(), &SliceType{enum}, borrower.FieldByName("past_suffixes").Type())
+	require.Nil(s.T(), enumsDefs.Definition("suffix"))
+
+	// enums hold texts
+	require.True(s.T(), (&tTextType{}).AssignableTo(enum))
+	require.True(s.T(), enum.AssignableTo(&tTextType{}))
+	require.True(s.T(), enum.AssignableTo(enum))
+	require.False(s.T(), enum.AssignableTo(borrower.FieldByName("state").Type()))
+	require.False(s.T(), (&tBoolType{}).AssignableTo(enum))
+}
+
+func (s *Zuite) TestEnums_setAndCompute() {
+	ws := enumsDefs.MustNewWorksheet("borrower")
+
+	ws.MustSet("suffix", NewText("Jr."))
+	require.Equal(s.T(), NewText("Jr."), ws.MustGet("suffix"))
+	require.Equal(s.T(), &Bool{true}, ws.MustGet("is_junior"))
+	require.Equal(s.T(), NewText("Jr."), ws.MustGet("formal_suffix"))
+
+	ws.MustSet("suffix", NewText("Sr."))
+	require.Equal(s.T(), &Bool{false}, ws.MustGet("is_junior"))
+
+	ws.MustUnset("suffix")
+	require.False(s.T(), ws.MustIsSet("suffix"))
+
+	ws.MustAppend("past_suffixes", NewText("III"))
+	require.Equal(s.T(), []Value{NewText("III")}, ws.MustGetSlice("past_suffixes"))
+}
+
+func (s *Zuite) TestEnums_errors() {
+	ws := enumsDefs.MustNewWorksheet("borrower")
+
+	err := ws.Set("suffix", NewText("Esq."))
+	require.EqualError(s.T(), err, `"Esq." is not a value of enum suffix`)
+
+	err = ws.Set("suffix", NewText("jr."))
+	require.EqualError(s.T(), err, `"jr." is not a value of enum suffix`)
+
+	err = ws.Set("suffix", &Bool{true})
+	require.EqualError(s.T(), err, "cannot assign value of type bool to field of type suffix")
+
+	err = ws.Append("past_suffixes", NewText("IV"))
+	require.EqualError(s.T(), err, `"IV" is not a value of enum suffix`)
+
+	err = ws.Set("state", NewText("Jr."))
+	require.EqualError(s.T(), err, `"Jr." is not a value of enum state`)
+
+	require.False(s.T(), ws.MustIsSet("suffix"))
+	require.Empty(s.T(), ws.MustGetSlice("past_suffixes"))
+}
+
+func (s *Zuite) TestEnums_definitionErrors() {
+	cases := map[string]string{
+		`enum e {}
+		worksheet w {}`: `1:9: e: enum must list at least one value`,
+
+		`enum e { "a", "a" }
+		worksheet w {}`: `1:15: e: value "a" listed more than once`,
+
+		`enum e { "a" "b" }
+		worksheet w {}`: `1:14: expected }, found "b"`,
+
+		`enum e { a }
+		worksheet w {}`: `1:10: expected text, found a`,
+
+		`enum e { "a" }
+		enum e { "b" }
+		worksheet w {}`: `2:3: multiple enums with name e`,
+
+		`enum e { "a" }
+		worksheet e {}`: `2:3: worksheet e has the same name as an enum`,
+
+		`enum e { "a" }
+		worksheet w {1:m map[e]}`: `2:16: w.m: map of non-worksheet type e`,
+	}
+	for input, msg := range cases {
+		_, err := NewDefinitions(strings.NewReader(input))
+		assert.EqualError(s.T(), err, msg, input)
+	}
+}
+
+func (s *DbZuite) TestEnums_saveLoad() {
+	ws := defs.MustNewWorksheet("with_enum")
+	ws.MustSet("suffix", NewText("Sr."))
+
+	s.MustRunTransaction(func(tx *runner.Tx) error {
+		session := s.store.Open(tx)
+		return session.Save(ws)
+	})
+
+	_, valuesRecs, _ := s.DbState()
+	require.Contains(s.T(), valuesRecs, rValueForTesting{
+		WorksheetId: ws.Id(),
+		Index:       1,
+		FromVersion: 1,
+		ToVersion:   math.MaxInt32,
+		Value:       "Sr.",
+	})
+
+	var fresh *Worksheet
+	s.MustRunTransaction(func(tx *runner.Tx) error {
+		session := s.store.Open(tx)
+		var err error
+		fresh, err = session.Load(ws.Id())
+		return err
+	})
+	require.Equal(s.T(), NewText("Sr."), fresh.MustGet("suffix"))
+}
@@ -14,11 +14,24 @@ package worksheets
 
 import (
 	"fmt"
+	"strings"
+	"time"
 )
 
 type expression interface {
+	// Args returns the names of the fields the expression depends on.
 	Args() []string
-	Compute(ws *Worksheet) (Value, error)
+
+	// Compute computes the expression over the worksheet, with locals holding
+	// the values of local variables in scope.
+	Compute(ws *Worksheet, locals map[string]Value) (Value, error)
+}
+
+// statement is executed as part of a block, and reports whether it returned,
+// in which case the value returned is that of the block.
+type statement interface {
+	Args() []string
+	exec(ws *Worksheet, locals map[string]Value) (Value, bool, error)
 }
 
 // Assert that all expressions implement the expression interface
@@ -27,20 +40,42 @@ var _ = []expression{
 	&Number{},
 	&Text{},
 	&Bool{},
+	&Date{},
+	&Time{},
+	&Tuple{},
 
 	&tExternal{},
 	&ePlugin{},
 	&tVar{},
+	&tSelector{},
+	&tLocal{},
 	&tUnop{},
 	&tBinop{},
 	&tReturn{},
+	&tBlock{},
+	&tCall{},
+	&tDuration{},
+	&tDateOf{},
+}
+
+// Assert that all statements implement the statement interface
+var _ = []statement{
+	&tExternal{},
+	&tReturn{},
+	&tAssign{},
+	&tIf{},
+	&tBlock{},
 }
 
 func (e *tExternal) Args() []string {
 	panic(fmt.Sprintf("unresolved plugin in worksheet"))
 }
 
-func (e *tExternal) Compute(ws *Worksheet) (Value, error) {
+func (e *tExternal) Compute(ws *Worksheet, locals map[string]Value) (Value, error) {
+	panic(fmt.Sprintf("unresolved plugin in worksheet(%s)", ws.def.name))
+}
+
+func (e *tExternal) exec(ws *Worksheet, locals map[string]Value) (Value, bool, error) {
 	panic(fmt.Sprintf("unresolved plugin in worksheet(%s)", ws.def.name))
 }
 
@@ -48,7 +83,7 @@ func (e *Undefined) Args() []string {
 	return nil
 }
 
-func (e *Undefined) Compute(ws *Worksheet) (Value, error) {
+func (e *Undefined) Compute(ws *Worksheet, locals map[string]Value) (Value, error) {
 	return e, nil
 }
 
@@ -56,7 +91,7 @@ func (e *Number) Args() []string {
 	return nil
 }
 
-func (e *Number) Compute(ws *Worksheet) (Value, error) {
+func (e *Number) Compute(ws *Worksheet, locals map[string]Value) (Value, error) {
 	return e, nil
 }
 
@@ -64,7 +99,7 @@ func (e *Text) Args() []string {
 	return nil
 }
 
-func (e *Text) Compute(ws *Worksheet) (Value, error) {
+func (e *Text) Compute(ws *Worksheet, locals map[string]Value) (Value, error) {
 	return e, nil
 }
 
@@ -72,7 +107,31 @@ func (e *Bool) Args() []string {
 	return nil
 }
 
-func (e *Bool) Compute(ws *Worksheet) (Value, error) {
+func (e *Bool) Compute(ws *Worksheet, locals map[string]Value) (Value, error) {
+	return e, nil
+}
+
+func (e *Date) Args() []string {
+	return nil
+}
+
+func (e *Date) Compute(ws *Worksheet, locals map[string]Value) (Value, error) {
+	return e, nil
+}
+
+func (e *Time) Args() []string {
+	return nil
+}
+
+func (e *Time) Compute(ws *Worksheet, locals map[string]Value) (Value, error) {
+	return e, nil
+}
+
+func (e *Tuple) Args() []string {
+	return nil
+}
+
+func (e *Tuple) Compute(ws *Worksheet, locals map[string]Value) (Value, error) {
 	return e, nil
 }
 
@@ -80,16 +139,79 @@ func (e *tVar) Args() []string {
 	return []string{e.name}
 }
 
-func (e *tVar) Compute(ws *Worksheet) (Value, error) {
-	return ws.Get(e.name)
+func (e *tVar) Compute(ws *Worksheet, locals map[string]Value) (Value, error) {
+	// Unlike Get, slices and maps are accessible in expressions.
+	_, value, err := ws.get(e.name)
+	return value, err
+}
+
+func (e *tSelector) Args() []string {
+	return e.expr.Args()
+}
+
+func (e *tSelector) Compute(ws *Worksheet, locals map[string]Value) (Value, error) {
+	value, err := e.expr.Compute(ws, locals)
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := value.(type) {
+	case *Undefined:
+		return v, nil
+	case *Worksheet:
+		_, fieldValue, err := v.get(e.field)
+		if err != nil {
+			return nil, fmt.Errorf("unknown field %s.%s", v.def.name, e.field)
+		}
+		return fieldValue, nil
+	case *slice:
+		// elements are either worksheets, or views
+		withFields, ok := v.typ.elementType.(interface {
+			FieldByName(name string) *Field
+		})
+		if !ok {
+			return nil, fmt.Errorf("cannot select %s on %s", e.field, v.typ)
+		}
+		field := withFields.FieldByName(e.field)
+		if field == nil {
+			return nil, fmt.Errorf("unknown field %s.%s", v.typ.elementType, e.field)
+		}
+		projection := &slice{
+			typ:      &SliceType{field.typ},
+			lastRank: v.lastRank,
+			elements: make([]sliceElement, len(v.elements)),
+		}
+		for i, element := range v.elements {
+			_, fieldValue, err := element.value.(*Worksheet).get(e.field)
+			if err != nil {
+				return nil, err
+			}
+			projection.elements[i] = sliceElement{element.rank, fieldValue}
+		}
+		return projection, nil
+	default:
+		return nil, fmt.Errorf("cannot select %s on %s", e.field, value.Type())
+	}
+}
+
+func (e *tLocal) Args() []string {
+	return nil
+}
+
+func (e *tLocal) Compute(ws *Worksheet, locals map[string]Value) (Value, error) {
+	value, ok := locals[e.name]
+	if !ok {
+		return nil, fmt.Errorf("local %s used before being assigned", e.name)
+	}
+	return value, nil
 }
 
 func (e *tUnop) Args() []string {
 	return e.expr.Args()
 }
 
-func (e *tUnop) Compute(ws *Worksheet) (Value, error) {
-	result, err := e.expr.Compute(ws)
+func (e *tUnop) Compute(ws *Worksheet, locals map[string]Value) (Value, error) {
+	result, err := e.expr.Compute(ws, locals)
 	if err != nil {
 		return nil, err
 	}
@@ -116,8 +238,8 @@ func (e *tBinop) Args() []string {
 	return append(left, right...)
 }
 
-func (e *tBinop) Compute(ws *Worksheet) (Value, error) {
-	left, err := e.left.Compute(ws)
+func (e *tBinop) Compute(ws *Worksheet, locals map[string]Value) (Value, error) {
+	left, err := e.left.Compute(ws, locals)
 	if err != nil {
 		return nil, err
 	}
@@ -137,7 +259,7 @@ func (e *tBinop) Compute(ws *Worksheet) (Value, error) {
 			return bLeft, nil
 		}
 
-		right, err := e.right.Compute(ws)
+		right, err := e.right.Compute(ws, locals)
 		if err != nil {
 			return nil, err
 		}
@@ -154,17 +276,70 @@ func (e *tBinop) Compute(ws *Worksheet) (Value, error) {
 		return bRight, nil
 	}
 
-	right, err := e.right.Compute(ws)
+	right, err := e.right.Compute(ws, locals)
 	if err != nil {
 		return nil, err
 	}
 
 	// equality
 	if e.op == opEqual {
-		return &Bool{left.Equal(right)}, nil
+		return &Bool{equal(left, right)}, nil
 	}
 	if e.op == opNotEqual {
-		return &Bool{!left.Equal(right)}, nil
+		return &Bool{!equal(left, right)}, nil
+	}
+
+	if _, ok := left.(*Undefined); ok {
+		return left, nil
+	}
+
+	if _, ok := right.(*Undefined); ok {
+		return right, nil
+	}
+
+	// ordering
+	switch e.op {
+	case opLess, opLessOrEqual, opGreater, opGreaterOrEqual:
+		cmp, err := compare(left, right)
+		if err != nil {
+			return nil, err
+		}
+		switch e.op {
+		case opLess:
+			return &Bool{cmp < 0}, nil
+		case opLessOrEqual:
+			return &Bool{cmp <= 0}, nil
+		case opGreater:
+			return &Bool{cmp > 0}, nil
+		default:
+			return &Bool{cmp >= 0}, nil
+		}
+	}
+
+	// date and time arithmetic
+	if d, ok := right.(*duration); ok {
+		if e.op == opMinus {
+			d = d.negate()
+		} else if e.op != opPlus {
+			return nil, fmt.Errorf("op on duration")
+		}
+		switch t := left.(type) {
+		case *Date:
+			return t.add(d)
+		case *Time:
+			return t.add(d), nil
+		default:
+			return nil, fmt.Errorf("duration added to non-date and non-time")
+		}
+	}
+
+	// text concatenation
+	if tLeft, ok := left.(*Text); ok {
+		tRight, ok := right.(*Text)
+		if !ok || e.op != opPlus {
+			return nil, fmt.Errorf("op on text")
+		}
+		return &Text{tLeft.value + tRight.value}, nil
 	}
 
 	// numerical operations
@@ -173,49 +348,236 @@ func (e *tBinop) Compute(ws *Worksheet) (Value, error) {
 		return nil, fmt.Errorf("op on non-number")
 	}
 
-	if _, ok := left.(*Undefined); ok {
-		return left, nil
-	}
-
 	nRight, ok := right.(*Number)
 	if !ok {
 		return nil, fmt.Errorf("op on non-number")
 	}
 
-	if _, ok := right.(*Undefined); ok {
-		return right, nil
-	}
-
 	var result *Number
 	switch e.op {
 	case opPlus:
-		result = nLeft.Plus(nRight)
+		result, err = nLeft.Plus(nRight)
 	case opMinus:
-		result = nLeft.Minus(nRight)
+		result, err = nLeft.Minus(nRight)
 	case opMult:
-		result = nLeft.Mult(nRight)
+		result, err = nLeft.Mult(nRight)
 	case opDiv:
 		if e.round == nil {
 			return nil, fmt.Errorf("division without rounding mode")
 		}
-		return nLeft.Div(nRight, e.round.mode, e.round.scale), nil
+		return nLeft.Div(nRight, e.round.mode, e.round.scale)
+	case opIntDiv:
+		result, err = nLeft.IntDiv(nRight)
+	case opMod:
+		result, err = nLeft.Mod(nRight)
 	default:
 		panic(fmt.Sprintf("not implemented for %s", e.op))
 	}
+	if err != nil {
+		return nil, err
+	}
 
 	if e.round != nil {
-		result = result.Round(e.round.mode, e.round.scale)
+		return result.Round(e.round.mode, e.round.scale)
 	}
 
 	return result, nil
 }
 
+// compare orders two defined values of the same kind, returning -1, 0 or 1
+// when left is respectively less than, equal to, or greater than right.
+func compare(left, right Value) (int, error) {
+	switch l := left.(type) {
+	case *Number:
+		if r, ok := right.(*Number); ok {
+			return l.compare(r), nil
+		}
+	case *Text:
+		if r, ok := right.(*Text); ok {
+			return strings.Compare(l.value, r.value), nil
+		}
+	case *Date:
+		if r, ok := right.(*Date); ok {
+			return compareTimes(l.value, r.value), nil
+		}
+	case *Time:
+		if r, ok := right.(*Time); ok {
+			return compareTimes(l.value, r.value), nil
+		}
+	}
+	return 0, fmt.Errorf("cannot compare %s and %s", left.Type(), right.Type())
+}
+
+// equal reports whether two values are equal, numbers being compared by value
+// across scales, like ordering does, e.g. 1.00 == 1.
+func equal(left, right Value) bool {
+	if l, ok := left.(*Number); ok {
+		if r, ok := right.(*Number); ok {
+			return l.compare(r) == 0
+		}
+	}
+	return left.Equal(right)
+}
+
+func compareTimes(left, right time.Time) int {
+	switch {
+	case left.Before(right):
+		return -1
+	case left.After(right):
+		return 1
+	default:
+		return 0
+	}
+}
+
 func (e *tReturn) Args() []string {
 	return e.expr.Args()
 }
 
-func (e *tReturn) Compute(ws *Worksheet) (Value, error) {
-	return e.expr.Compute(ws)
+func (e *tReturn) Compute(ws *Worksheet, locals map[string]Value) (Value, error) {
+	return e.expr.Compute(ws, locals)
+}
+
+func (e *tReturn) exec(ws *Worksheet, locals map[string]Value) (Value, bool, error) {
+	value, err := e.expr.Compute(ws, locals)
+	return value, true, err
+}
+
+func (e *tAssign) Args() []string {
+	return e.expr.Args()
+}
+
+func (e *tAssign) exec(ws *Worksheet, locals map[string]Value) (Value, bool, error) {
+	value, err := e.expr.Compute(ws, locals)
+	if err != nil {
+		return nil, false, err
+	}
+	locals[e.name] = value
+	return nil, false, nil
+}
+
+func (e *tIf) Args() []string {
+	args := append(e.cond.Args(), e.then.Args()...)
+	if e.otherwise != nil {
+		args = append(args, e.otherwise.Args()...)
+	}
+	return args
+}
+
+func (e *tIf) exec(ws *Worksheet, locals map[string]Value) (Value, bool, error) {
+	cond, err := e.cond.Compute(ws, locals)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// An undefined condition makes the whole computation undefined.
+	if _, ok := cond.(*Undefined); ok {
+		return cond, true, nil
+	}
+
+	bCond, ok := cond.(*Bool)
+	if !ok {
+		return nil, false, fmt.Errorf("if on non-bool")
+	}
+
+	if bCond.value {
+		return e.then.exec(ws, locals)
+	} else if e.otherwise != nil {
+		return e.otherwise.exec(ws, locals)
+	}
+	return nil, false, nil
+}
+
+func (e *tBlock) Args() []string {
+	var args []string
+	for _, stmt := range e.stmts {
+		args = append(args, stmt.Args()...)
+	}
+	return args
+}
+
+func (e *tBlock) Compute(ws *Worksheet, locals map[string]Value) (Value, error) {
+	if locals == nil {
+		locals = make(map[string]Value)
+	}
+	value, returned, err := e.exec(ws, locals)
+	if err != nil {
+		return nil, err
+	} else if !returned {
+		// unexpected since blocks are checked to terminate when parsing
+		panic("block did not return")
+	}
+	return value, nil
+}
+
+func (e *tBlock) exec(ws *Worksheet, locals map[string]Value) (Value, bool, error) {
+	for _, stmt := range e.stmts {
+		value, returned, err := stmt.exec(ws, locals)
+		if err != nil || returned {
+			return value, returned, err
+		}
+	}
+	return nil, false, nil
+}
+
+func (e *tDuration) Args() []string {
+	return e.amount.Args()
+}
+
+func (e *tDuration) Compute(ws *Worksheet, locals map[string]Value) (Value, error) {
+	amount, err := e.amount.Compute(ws, locals)
+	if err != nil {
+		return nil, err
+	}
+
+	switch a := amount.(type) {
+	case *Undefined:
+		return a, nil
+	case *Number:
+		if a.typ.scale != 0 {
+			return nil, fmt.Errorf("duration must be a whole number, found %s", a)
+		}
+		return &duration{a.value, e.unit}, nil
+	default:
+		return nil, fmt.Errorf("duration must be a number")
+	}
+}
+
+func (e *tDateOf) Args() []string {
+	return append(e.time.Args(), e.tz.Args()...)
+}
+
+func (e *tDateOf) Compute(ws *Worksheet, locals map[string]Value) (Value, error) {
+	value, err := e.time.Compute(ws, locals)
+	if err != nil {
+		return nil, err
+	}
+	tz, err := e.tz.Compute(ws, locals)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := value.(*Undefined); ok {
+		return value, nil
+	}
+	if _, ok := tz.(*Undefined); ok {
+		return tz, nil
+	}
+
+	t, ok := value.(*Time)
+	if !ok {
+		return nil, fmt.Errorf("date conversion on non-time")
+	}
+	name, ok := tz.(*Text)
+	if !ok {
+		return nil, fmt.Errorf("date conversion with non-text time zone")
+	}
+	loc, err := time.LoadLocation(name.value)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %s", name.value)
+	}
+
+	return t.Date(loc), nil
 }
 
 type ePlugin struct {
@@ -226,7 +588,7 @@ func (e *ePlugin) Args() []string {
 	return e.computedBy.Args()
 }
 
-func (e *ePlugin) Compute(ws *Worksheet) (Value, error) {
+func (e *ePlugin) Compute(ws *Worksheet, locals map[string]Value) (Value, error) {
 	args := e.computedBy.Args()
 	values := make([]Value, len(args), len(args))
 	for i, arg := range args {
@@ -0,0 +1,168 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"strings"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mapFS(contents map[string]string) fstest.MapFS {
+	fsys := make(fstest.MapFS)
+	for name, data := range contents {
+		fsys[name] = &fstest.MapFile{Data: []byte(data)}
+	}
+	return fsys
+}
+
+func (s *Zuite) TestImports_multipleFiles() {
+	fsys := mapFS(map[string]string{
+		"borrower.ws": `worksheet borrower {
+			1:name text
+		}`,
+		"loan.ws": `worksheet loan {
+			1:borrower borrower
+			2:borrower_name text computed_by { return borrower.name }
+		}`,
+	})
+
+	defs, err := NewDefinitionsFromFiles(fsys, []string{"loan.ws", "borrower.ws"})
+	require.NoError(s.T(), err)
+
+	borrower := defs.MustNewWorksheet("borrower")
+	borrower.MustSet("name", alice)
+	loan := defs.MustNewWorksheet("loan")
+	loan.MustSet("borrower", borrower)
+	require.Equal(s.T(), `"Alice"`, loan.MustGet("borrower_name").String())
+}
+
+func (s *Zuite) TestImports_import() {
+	fsys := mapFS(map[string]string{
+		"people/borrower.ws": `
+		import "names.ws"
+
+		worksheet borrower {
+			1:name name
+		}`,
+		"people/names.ws": `worksheet name {
+			1:first text
+		}`,
+		"loans/loan.ws": `
+		import "../people/borrower.ws"
+
+		worksheet loan {
+			1:borrower borrower
+		}`,
+	})
+
+	defs, err := NewDefinitionsFromFiles(fsys, []string{"loans/loan.ws"})
+	require.NoError(s.T(), err)
+	for _, name := range []string{"loan", "borrower", "name"} {
+		assert.NotNil(s.T(), defs.Definition(name), name)
+	}
+}
+
+func (s *Zuite) TestImports_cycles() {
+	fsys := mapFS(map[string]string{
+		"a.ws": `
+		import "b.ws"
+		import "a.ws"
+
+		worksheet a {
+			1:b b
+		}`,
+		"b.ws": `
+		import "a.ws"
+
+		worksheet b {
+			1:a a
+		}`,
+	})
+
+	defs, err := NewDefinitionsFromFiles(fsys, []string{"a.ws", "b.ws", "./a.ws"})
+	require.NoError(s.T(), err)
+	assert.NotNil(s.T(), defs.Definition("a"))
+	assert.NotNil(s.T(), defs.Definition("b"))
+}
+
+func (s *Zuite) TestImports_errors() {
+	cases := []struct {
+		files     map[string]string
+		filenames []string
+		expected  string
+	}{
+		{
+			map[string]string{},
+			[]string{"missing.ws"},
+			`open missing.ws: file does not exist`,
+		},
+		{
+			map[string]string{
+				"a.ws": `import "missing.ws" worksheet a {}`,
+			},
+			[]string{"a.ws"},
+			`a.ws:1:1: open missing.ws: file does not exist`,
+		},
+		{
+			map[string]string{
+				"a.ws": `import "b.ws" worksheet a {}`,
+				"b.ws": "worksheet b {\n\t0:name text\n}",
+			},
+			[]string{"a.ws"},
+			`b.ws:2:2: b.name: index cannot be zero`,
+		},
+		{
+			map[string]string{
+				"a.ws": `worksheet a {}`,
+				"b.ws": `worksheet a {}`,
+			},
+			[]string{"a.ws", "b.ws"},
+			`b.ws:1:1: multiple worksheets with name a`,
+		},
+		{
+			map[string]string{
+				"a.ws": `worksheet a {} import "b.ws"`,
+				"b.ws": `worksheet b {}`,
+			},
+			[]string{"a.ws"},
+			`a.ws:1:16: imports must come before definitions`,
+		},
+		{
+			map[string]string{
+				"a.ws": `worksheet a { 1:b b }`,
+				"b.ws": `worksheet b { 1:name text`,
+			},
+			[]string{"a.ws", "b.ws"},
+			"b.ws:1:26: expected index, found ",
+		},
+		{
+			map[string]string{
+				"a.ws": `enum e { "a" }`,
+			},
+			[]string{"a.ws"},
+			`expecting worksheet`,
+		},
+	}
+	for _, ex := range cases {
+		_, err := NewDefinitionsFromFiles(mapFS(ex.files), ex.filenames)
+		assert.EqualError(s.T(), err, ex.expected, ex.filenames[0])
+	}
+}
+
+func (s *Zuite) TestImports_requireFiles() {
+	_, err := NewDefinitions(strings.NewReader(`import "other.ws" worksheet simple {}`))
+	require.EqualError(s.T(), err, `1:1: import "other.ws": imports require definitions read from files`)
+}
@@ -0,0 +1,407 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"math"
+	"strings"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+var mapsDefs = MustNewDefinitions(strings.NewReader(`
+worksheet person {
+	1:last_name text
+	2:birth_year number[0]
+	3:age number[0] computed_by {
+		return 2018 - birth_year
+	}
+	4:nickname text
+	keyed_by {
+		last_name
+		age
+	}
+}
+
+worksheet by_identity {
+	1:name text
+	keyed_by identity
+}
+
+worksheet directory {
+	1:people map[person]
+	2:others map[by_identity]
+	3:name text
+}`))
+
+func (s *Zuite) newPerson(lastName string, birthYear string) *Worksheet {
+	person := mapsDefs.MustNewWorksheet("person")
+	person.MustSet("last_name", NewText(lastName))
+	person.MustSet("birth_year", MustNewValue(birthYear))
+	return person
+}
+
+func (s *Zuite) TestMaps_parse() {
+	person := mapsDefs.defs["person"]
+	require.Equal(s.T(), []string{"last_name", "age"}, person.keyedBy)
+	require.Equal(s.T(), []*Field{
+		person.fieldsByName["last_name"],
+		person.fieldsByName["age"],
+	}, person.keyFields)
+	require.Equal(s.T(), map[int]bool{1: true, 2: true, 3: true}, person.frozenFields)
+
+	byIdentity := mapsDefs.defs["by_identity"]
+	require.Equal(s.T(), []string{"id"}, byIdentity.keyedBy)
+	require.Equal(s.T(), map[int]bool{IndexId: true}, byIdentity.frozenFields)
+
+	directory := mapsDefs.defs["directory"]
+	require.Equal(s.T(), &MapType{person}, directory.fieldsByName["people"].typ)
+	require.Equal(s.T(), "map[person]", directory.fieldsByName["people"].typ.String())
+}
+
+func (s *Zuite) TestMaps_putHasGetDel() {
+	var (
+		directory = mapsDefs.MustNewWorksheet("directory")
+		hopper    = s.newPerson("Hopper", "1906")
+		lovelace  = s.newPerson("Lovelace", "1815")
+		turing    = s.newPerson("Turing", "1912")
+	)
+
+	require.Nil(s.T(), directory.MustGetMap("people"))
+	require.False(s.T(), directory.MustHas("people", NewText("Hopper"), MustNewValue("112")))
+
+	directory.MustPut("people", turing)
+	directory.MustPut("people", hopper)
+	directory.MustPut("people", lovelace)
+
+	// iteration in insertion order
+	require.Equal(s.T(), []Value{turing, hopper, lovelace}, directory.MustGetMap("people"))
+
+	require.True(s.T(), directory.MustHas("people", NewText("Hopper"), MustNewValue("112")))
+	require.False(s.T(), directory.MustHas("people", NewText("Hopper"), MustNewValue("113")))
+
+	// putting under an existing key replaces in place
+	otherHopper := s.newPerson("Hopper", "1906")
+	otherHopper.MustSet("nickname", NewText("Amazing Grace"))
+	directory.MustPut("people", otherHopper)
+	require.Equal(s.T(), []Value{turing, otherHopper, lovelace}, directory.MustGetMap("people"))
+
+	directory.MustDelKey("people", NewText("Turing"), MustNewValue("106"))
+	require.Equal(s.T(), []Value{otherHopper, lovelace}, directory.MustGetMap("people"))
+	require.False(s.T(), directory.MustHas("people", NewText("Turing"), MustNewValue("106")))
+
+	// ranks keep growing
+	directory.MustPut("people", turing)
+	require.Equal(s.T(), []mapEntry{
+		{2, `"Hopper", 112`, otherHopper},
+		{3, `"Lovelace", 203`, lovelace},
+		{4, `"Turing", 106`, turing},
+	}, directory.data[1].(*mapValue).entries)
+
+	err := directory.DelKey("people", NewText("Babbage"), MustNewValue("227"))
+	require.EqualError(s.T(), err, `no worksheet with key "Babbage", 227`)
+}
+
+func (s *Zuite) TestMaps_tupleKeys() {
+	var (
+		directory = mapsDefs.MustNewWorksheet("directory")
+		hopper    = s.newPerson("Hopper", "1906")
+	)
+
+	directory.MustPut("people", hopper)
+	require.True(s.T(), directory.MustHas("people", MustNewValue(`tuple("Hopper", 112)`)))
+	require.False(s.T(), directory.MustHas("people", MustNewValue(`tuple("Hopper", 113)`)))
+
+	_, err := directory.Has("people", MustNewValue(`tuple("Hopper")`))
+	require.EqualError(s.T(), err, "person: key has 2 fields, 1 given")
+
+	directory.MustDelKey("people", MustNewTuple(NewText("Hopper"), MustNewValue("112")))
+	require.Empty(s.T(), directory.MustGetMap("people"))
+}
+
+func (s *Zuite) TestMaps_numberKeysAreNotRounded() {
+	defs := MustNewDefinitions(strings.NewReader(`
+	worksheet rate {
+		1:term number[1]
+		keyed_by { term }
+	}
+
+	worksheet schedule {
+		1:rates map[rate]
+	}`))
+
+	var (
+		schedule = defs.MustNewWorksheet("schedule")
+		one      = defs.MustNewWorksheet("rate")
+		two      = defs.MustNewWorksheet("rate")
+	)
+	one.MustSet("term", MustNewValue("1"))
+	two.MustSet("term", MustNewValue("1.9"))
+	schedule.MustPut("rates", one)
+	schedule.MustPut("rates", two)
+
+	// narrower keys are widened to the scale of the key field
+	require.True(s.T(), schedule.MustHas("rates", MustNewValue("1")))
+	require.True(s.T(), schedule.MustHas("rates", MustNewValue("1.0")))
+	require.True(s.T(), schedule.MustHas("rates", MustNewValue("1.9")))
+
+	// wider keys are rejected, rather than rounded to another key
+	_, err := schedule.Has("rates", MustNewValue("1.95"))
+	require.EqualError(s.T(), err, "rate: key field term of type number[1], number[2] given")
+
+	err = schedule.DelKey("rates", MustNewValue("1.95"))
+	require.EqualError(s.T(), err, "rate: key field term of type number[1], number[2] given")
+
+	err = schedule.Put("rates", one)
+	require.NoError(s.T(), err)
+
+	require.Equal(s.T(), []Value{one, two}, schedule.MustGetMap("rates"))
+}
+
+func (s *Zuite) TestMaps_keyedByIdentity() {
+	var (
+		directory = mapsDefs.MustNewWorksheet("directory")
+		other     = mapsDefs.MustNewWorksheet("by_identity")
+	)
+
+	directory.MustPut("others", other)
+	require.True(s.T(), directory.MustHas("others", NewText(other.Id())))
+
+	directory.MustDelKey("others", NewText(other.Id()))
+	require.Empty(s.T(), directory.MustGetMap("others"))
+}
+
+func (s *Zuite) TestMaps_keyIsFrozen() {
+	var (
+		directory = mapsDefs.MustNewWorksheet("directory")
+		hopper    = s.newPerson("Hopper", "1906")
+	)
+
+	// computing an edit does not freeze
+	_, err := directory.ComputeEdit(NewEdit().Put("people", hopper))
+	require.NoError(s.T(), err)
+	hopper.MustSet("birth_year", MustNewValue("1907"))
+
+	directory.MustPut("people", hopper)
+
+	err = hopper.Set("last_name", NewText("Murray"))
+	require.EqualError(s.T(), err, "cannot assign to frozen field last_name")
+
+	// inputs to computed key fields are frozen too
+	err = hopper.Unset("birth_year")
+	require.EqualError(s.T(), err, "cannot assign to frozen field birth_year")
+
+	hopper.MustSet("nickname", NewText("Amazing Grace"))
+
+	// deleting from the map thaws the worksheet
+	directory.MustDelKey("people", NewText("Hopper"), MustNewValue("111"))
+	hopper.MustSet("last_name", NewText("Murray"))
+}
+
+func (s *Zuite) TestMaps_keyIsFrozenWhileInAnyMap() {
+	var (
+		directory = mapsDefs.MustNewWorksheet("directory")
+		other     = mapsDefs.MustNewWorksheet("directory")
+		hopper    = s.newPerson("Hopper", "1906")
+	)
+	directory.MustPut("people", hopper)
+	other.MustPut("people", hopper)
+
+	directory.MustDelKey("people", NewText("Hopper"), MustNewValue("112"))
+	err := hopper.Set("last_name", NewText("Murray"))
+	require.EqualError(s.T(), err, "cannot assign to frozen field last_name")
+
+	// replacing an entry removes the worksheet it replaces from the map
+	other.MustPut("people", s.newPerson("Hopper", "1906"))
+	hopper.MustSet("last_name", NewText("Murray"))
+}
+
+func (s *Zuite) TestMaps_errors() {
+	var (
+		directory = mapsDefs.MustNewWorksheet("directory")
+		nameless  = mapsDefs.MustNewWorksheet("person")
+	)
+
+	cases := map[string]*Edit{
+		`Put on non-map field name`:                                NewEdit().Put("name", nameless),
+		`DelKey on non-map field name`:                             NewEdit().DelKey("name", alice),
+		`cannot put text in map[person]`:                           NewEdit().Put("people", alice),
+		`cannot put by_identity in map[person]`:                    NewEdit().Put("people", mapsDefs.MustNewWorksheet("by_identity")),
+		`person: key field last_name is undefined`:                 NewEdit().Put("people", nameless),
+		`Set on map field people, use Put, or DelKey`:              NewEdit().Set("people", nameless),
+		`Unset on map field people, must use DelKey`:               NewEdit().Unset("people"),
+		`person: key has 2 fields, 1 given`:                        NewEdit().DelKey("people", alice),
+		`person: key field age of type number[0], text given`:      NewEdit().DelKey("people", alice, bob),
+		`person: key field age of type number[0], number[1] given`: NewEdit().DelKey("people", alice, MustNewValue("1.5")),
+	}
+	for msg, edit := range cases {
+		_, err := directory.ComputeEdit(edit)
+		assert.EqualError(s.T(), err, msg)
+	}
+
+	_, err := directory.Get("people")
+	require.EqualError(s.T(), err, "Get on map field people, use GetMap")
+
+	_, err = directory.GetMap("name")
+	require.EqualError(s.T(), err, "GetMap on non-map field name")
+
+	_, err = directory.Has("name", alice)
+	require.EqualError(s.T(), err, "Has on non-map field name")
+}
+
+func (s *Zuite) TestMaps_definitionErrors() {
+	cases := map[string]string{
+		`worksheet not_keyed {1:name text}
+		worksheet with_map {1:m map[not_keyed]}`: `2:23: with_map.m: map of non-keyed worksheet not_keyed`,
+
+		`worksheet with_map {1:m map[text]}`: `1:21: with_map.m: map of non-worksheet type text`,
+
+		`worksheet with_map {1:m map[unknown]}`: `1:21: with_map.m: unknown worksheet unknown referenced`,
+
+		`worksheet keyed {
+			1:name text
+			keyed_by { unknown }
+		}`: `1:1: keyed: keyed_by unknown field unknown`,
+
+		`worksheet keyed {
+			1:names []text
+			keyed_by { names }
+		}`: `2:4: keyed: keyed_by field names must be of base type, was []text`,
+
+		`worksheet keyed {
+			1:name text
+			keyed_by { name name }
+		}`: `1:1: keyed: keyed_by field name listed more than once`,
+
+		`worksheet keyed {
+			1:name text
+			keyed_by { name }
+			keyed_by identity
+		}`: `4:4: keyed: multiple keyed_by`,
+
+		`worksheet keyed {
+			1:name text
+			keyed_by { }
+		}`: `3:15: keyed_by must list at least one field`,
+	}
+	for input, msg := range cases {
+		_, err := NewDefinitions(strings.NewReader(input))
+		assert.EqualError(s.T(), err, msg, input)
+	}
+}
+
+type rMapEntryForTesting struct {
+	MapId       string
+	Rank        int
+	Key         string
+	FromVersion int
+	ToVersion   int
+	Value       string
+}
+
+func (s *DbZuite) DbMapEntries() []rMapEntryForTesting {
+	var dbMapEntriesRecs []rMapEntry
+	err := s.db.
+		Select("*").
+		From("worksheet_map_entries").
+		OrderBy("map_id, rank, from_version").
+		QueryStructs(&dbMapEntriesRecs)
+	require.NoError(s.T(), err)
+
+	mapEntriesRecs := make([]rMapEntryForTesting, len(dbMapEntriesRecs))
+	for i, dbMapEntryRec := range dbMapEntriesRecs {
+		mapEntriesRecs[i] = rMapEntryForTesting{
+			MapId:       dbMapEntryRec.MapId,
+			Rank:        dbMapEntryRec.Rank,
+			Key:         dbMapEntryRec.Key,
+			FromVersion: dbMapEntryRec.FromVersion,
+			ToVersion:   dbMapEntryRec.ToVersion,
+			Value:       dbMapEntryRec.Value.String,
+		}
+	}
+	return mapEntriesRecs
+}
+
+func (s *DbZuite) TestMapSaveUpdateLoad() {
+	var (
+		ws    = defs.MustNewWorksheet("with_map")
+		alice = defs.MustNewWorksheet("keyed_by_name")
+		bob   = defs.MustNewWorksheet("keyed_by_name")
+	)
+	alice.MustSet("name", NewText("Alice"))
+	bob.MustSet("name", NewText("Bob"))
+	ws.MustPut("people", alice)
+
+	s.MustRunTransaction(func(tx *runner.Tx) error {
+		session := s.store.Open(tx)
+		return session.Save(ws)
+	})
+
+	theMapId := ws.data[12].(*mapValue).id
+	require.Equal(s.T(), []rMapEntryForTesting{
+		{
+			MapId:       theMapId,
+			Rank:        1,
+			Key:         `"Alice"`,
+			FromVersion: 1,
+			ToVersion:   math.MaxInt32,
+			Value:       "*:" + alice.Id(),
+		},
+	}, s.DbMapEntries())
+
+	ws.MustPut("people", bob)
+	ws.MustDelKey("people", NewText("Alice"))
+
+	s.MustRunTransaction(func(tx *runner.Tx) error {
+		session := s.store.Open(tx)
+		return session.Update(ws)
+	})
+
+	require.Equal(s.T(), []rMapEntryForTesting{
+		{
+			MapId:       theMapId,
+			Rank:        1,
+			Key:         `"Alice"`,
+			FromVersion: 1,
+			ToVersion:   1,
+			Value:       "*:" + alice.Id(),
+		},
+		{
+			MapId:       theMapId,
+			Rank:        2,
+			Key:         `"Bob"`,
+			FromVersion: 2,
+			ToVersion:   math.MaxInt32,
+			Value:       "*:" + bob.Id(),
+		},
+	}, s.DbMapEntries())
+
+	var fresh *Worksheet
+	s.MustRunTransaction(func(tx *runner.Tx) error {
+		session := s.store.Open(tx)
+		var err error
+		fresh, err = session.Load(ws.Id())
+		return err
+	})
+
+	people := fresh.MustGetMap("people")
+	require.Len(s.T(), people, 1)
+	freshBob := people[0].(*Worksheet)
+	require.Equal(s.T(), bob.Id(), freshBob.Id())
+	require.True(s.T(), fresh.MustHas("people", NewText("Bob")))
+
+	err := freshBob.Set("name", NewText("Robert"))
+	require.EqualError(s.T(), err, "cannot assign to frozen field name")
+}
@@ -0,0 +1,168 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"strings"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+var packagesFS = mapFS(map[string]string{
+	"mortgage/borrower.ws": `
+	package mortgage
+
+	view named {
+		name text
+	}
+
+	enum suffix { "Jr.", "Sr." }
+
+	worksheet borrower implements named {
+		1:name text
+		2:suffix suffix
+	}`,
+	"mortgage/loan.ws": `
+	package mortgage
+
+	import "borrower.ws"
+
+	worksheet loan {
+		1:borrower borrower
+		2:co_borrowers []borrower
+		3:borrower_name text computed_by { return borrower.name }
+	}`,
+	"servicing/loan.ws": `
+	package servicing
+
+	import "../mortgage/loan.ws"
+
+	worksheet loan {
+		1:origination mortgage.loan
+		2:named mortgage.named
+	}`,
+})
+
+var packagesDefs = MustNewDefinitionsFromFiles(packagesFS, []string{"servicing/loan.ws"})
+
+func (s *Zuite) TestPackages_qualifiedNames() {
+	for _, name := range []string{"mortgage.borrower", "mortgage.loan", "servicing.loan"} {
+		assert.NotNil(s.T(), packagesDefs.Definition(name), name)
+	}
+	assert.Nil(s.T(), packagesDefs.Definition("borrower"))
+
+	borrower := packagesDefs.MustNewWorksheet("mortgage.borrower")
+	require.Equal(s.T(), "mortgage.borrower", borrower.Name())
+	borrower.MustSet("name", alice)
+
+	loan := packagesDefs.MustNewWorksheet("mortgage.loan")
+	loan.MustSet("borrower", borrower)
+	require.Equal(s.T(), alice, loan.MustGet("borrower_name"))
+
+	servicing := packagesDefs.MustNewWorksheet("servicing.loan")
+	servicing.MustSet("origination", loan)
+	servicing.MustSet("named", borrower)
+
+	err := servicing.Set("origination", borrower)
+	require.EqualError(s.T(), err, "cannot assign value of type mortgage.borrower to field of type mortgage.loan")
+}
+
+func (s *Zuite) TestPackages_unqualifiedNamesWithoutPackage() {
+	defs := MustNewDefinitions(strings.NewReader(`
+	worksheet simple {
+		1:ref other
+	}
+	worksheet other {
+		1:name text
+	}`))
+	require.Equal(s.T(), "simple", defs.MustNewWorksheet("simple").Name())
+}
+
+func (s *Zuite) TestPackages_errors() {
+	cases := map[string]string{
+		`package mortgage
+		worksheet loan {
+			1:borrower borrower
+		}`: `3:4: mortgage.loan.borrower: unknown worksheet mortgage.borrower referenced`,
+
+		`worksheet loan {
+			1:borrower mortgage.borrower
+		}`: `2:4: loan.borrower: unknown worksheet mortgage.borrower referenced`,
+
+		`package mortgage
+		worksheet loan implements named {}`: `2:3: mortgage.loan: implements unknown view mortgage.named`,
+
+		`package mortgage
+		package servicing
+		worksheet loan {}`: `2:3: package must come first`,
+
+		`worksheet loan {}
+		package mortgage`: `2:3: package must come first`,
+
+		`package 5
+		worksheet loan {}`: `1:9: expected name, found 5`,
+
+		`package mortgage
+		worksheet loan {
+			1:borrower mortgage.
+		}`: `4:3: expected name, found }`,
+	}
+	for input, expected := range cases {
+		_, err := NewDefinitions(strings.NewReader(input))
+		assert.EqualError(s.T(), err, expected, input)
+	}
+}
+
+func (s *Zuite) TestPackages_sameNameInDifferentPackages() {
+	defs := MustNewDefinitions(strings.NewReader(`
+	worksheet loan {
+		1:amount number[2]
+	}`))
+	require.NotNil(s.T(), defs.Definition("loan"))
+
+	require.NotEqual(s.T(), packagesDefs.Definition("mortgage.loan"), packagesDefs.Definition("servicing.loan"))
+}
+
+func (s *DbZuite) TestPackages_saveLoad() {
+	var (
+		store  = NewStore(packagesDefs)
+		loanId string
+	)
+	s.MustRunTransaction(func(tx *runner.Tx) error {
+		borrower := packagesDefs.MustNewWorksheet("mortgage.borrower")
+		borrower.MustSet("name", alice)
+		loan := packagesDefs.MustNewWorksheet("mortgage.loan")
+		loan.MustSet("borrower", borrower)
+		loanId = loan.Id()
+
+		return store.Open(tx).Save(loan)
+	})
+
+	wsRecs, _, _ := s.DbState()
+	var names []string
+	for _, wsRec := range wsRecs {
+		names = append(names, wsRec.Name)
+	}
+	require.ElementsMatch(s.T(), []string{"mortgage.borrower", "mortgage.loan"}, names)
+
+	var fresh *Worksheet
+	s.MustRunTransaction(func(tx *runner.Tx) error {
+		var err error
+		fresh, err = store.Open(tx).Load(loanId)
+		return err
+	})
+	require.Equal(s.T(), "mortgage.loan", fresh.Name())
+	require.Equal(s.T(), alice, fresh.MustGet("borrower_name"))
+}
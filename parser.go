@@ -23,14 +23,36 @@ import (
 
 type parser struct {
 	s    *scanner.Scanner
-	toks []string
+	toks []token
+
+	// pos holds the position of the last token read, or peeked, and depth
+	// the number of curly braces opened and not yet closed. Both are used to
+	// report errors, and to recover from them.
+	pos   scanner.Position
+	depth int
+
+	// doc holds the documentation of the last token read, or peeked, and line
+	// the line of the last token scanned.
+	doc  string
+	line int
+
+	// pkg holds the package of the definitions being parsed, if any.
+	pkg string
+
+	// scopes holds the local variables declared in the blocks being parsed,
+	// innermost block last.
+	scopes []map[string]bool
 }
 
 func newParser(src io.Reader) *parser {
-	s := &scanner.Scanner{
-		Mode: scanner.GoTokens,
-	}
+	s := &scanner.Scanner{}
 	s.Init(src)
+
+	// Comments are scanned, rather than skipped, to keep documentation.
+	s.Mode = scanner.GoTokens &^ scanner.SkipComments
+	if file, ok := src.(interface{ Name() string }); ok {
+		s.Filename = file.Name()
+	}
 	return &parser{
 		s: s,
 	}
@@ -38,59 +60,283 @@ func newParser(src io.Reader) *parser {
 
 var (
 	// tokens
-	pLacco      = newTokenPattern("{", "\\{")
-	pRacco      = newTokenPattern("}", "\\}")
-	pLparen     = newTokenPattern("(", "\\(")
-	pRparen     = newTokenPattern(")", "\\)")
-	pLbracket   = newTokenPattern("[", "\\[")
-	pRbracket   = newTokenPattern("]", "\\]")
-	pColon      = newTokenPattern(":", "\\:")
-	pPlus       = newTokenPattern("+", "\\+")
-	pMinus      = newTokenPattern("-", "\\-")
-	pMult       = newTokenPattern("*", "\\*")
-	pDiv        = newTokenPattern("/", "\\/")
-	pNot        = newTokenPattern("!", "\\!")
-	pEqual      = newTokenPattern("==", "\\=\\=")
-	pNotEqual   = newTokenPattern("!=", "\\!\\=")
-	pAnd        = newTokenPattern("&&", "\\&\\&")
-	pOr         = newTokenPattern("||", "\\|\\|")
-	pWorksheet  = newTokenPattern("worksheet", "worksheet")
-	pComputedBy = newTokenPattern("computed_by", "computed_by")
-	pExternal   = newTokenPattern("external", "external")
-	pUndefined  = newTokenPattern("undefined", "undefined")
-	pTrue       = newTokenPattern("true", "true")
-	pFalse      = newTokenPattern("false", "false")
-	pRound      = newTokenPattern("round", "round")
-	pReturn     = newTokenPattern("return", "return")
-	pUp         = newTokenPattern(string(ModeUp), string(ModeUp))
-	pDown       = newTokenPattern(string(ModeDown), string(ModeDown))
-	pHalf       = newTokenPattern(string(ModeHalf), string(ModeHalf))
+	pLacco          = newTokenPattern("{", "\\{")
+	pRacco          = newTokenPattern("}", "\\}")
+	pLparen         = newTokenPattern("(", "\\(")
+	pRparen         = newTokenPattern(")", "\\)")
+	pLbracket       = newTokenPattern("[", "\\[")
+	pRbracket       = newTokenPattern("]", "\\]")
+	pColon          = newTokenPattern(":", "\\:")
+	pDot            = newTokenPattern(".", "\\.")
+	pComma          = newTokenPattern(",", "\\,")
+	pPlus           = newTokenPattern("+", "\\+")
+	pMinus          = newTokenPattern("-", "\\-")
+	pMult           = newTokenPattern("*", "\\*")
+	pDiv            = newTokenPattern("/", "\\/")
+	pIntDiv         = newTokenPattern("div", "div")
+	pMod            = newTokenPattern("%", "\\%")
+	pNot            = newTokenPattern("!", "\\!")
+	pEqual          = newTokenPattern("==", "\\=\\=")
+	pNotEqual       = newTokenPattern("!=", "\\!\\=")
+	pAnd            = newTokenPattern("&&", "\\&\\&")
+	pOr             = newTokenPattern("||", "\\|\\|")
+	pLess           = newTokenPattern("<", "\\<")
+	pLessOrEqual    = newTokenPattern("<=", "\\<\\=")
+	pGreater        = newTokenPattern(">", "\\>")
+	pGreaterOrEqual = newTokenPattern(">=", "\\>\\=")
+	pWorksheet      = newTokenPattern("worksheet", "worksheet")
+	pView           = newTokenPattern("view", "view")
+	pEnum           = newTokenPattern("enum", "enum")
+	pImport         = newTokenPattern("import", "import")
+	pPackage        = newTokenPattern("package", "package")
+	pImplements     = newTokenPattern("implements", "implements")
+	pComputedBy     = newTokenPattern("computed_by", "computed_by")
+	pConstrainedBy  = newTokenPattern("constrained_by", "constrained_by")
+	pExternal       = newTokenPattern("external", "external")
+	pKeyedBy        = newTokenPattern("keyed_by", "keyed_by")
+	pIdentity       = newTokenPattern("identity", "identity")
+	pUndefined      = newTokenPattern("undefined", "undefined")
+	pTrue           = newTokenPattern("true", "true")
+	pFalse          = newTokenPattern("false", "false")
+	pRound          = newTokenPattern("round", "round")
+	pReturn         = newTokenPattern("return", "return")
+	pIf             = newTokenPattern("if", "if")
+	pElse           = newTokenPattern("else", "else")
+	pAssign         = newTokenPattern("=", "\\=")
+	pDeclare        = newTokenPattern(":=", "\\:\\=")
+	pDate           = newTokenPattern("date", "date")
+	pTime           = newTokenPattern("time", "time")
+	pTuple          = newTokenPattern("tuple", "tuple")
+	pUp             = newTokenPattern(string(ModeUp), string(ModeUp))
+	pDown           = newTokenPattern(string(ModeDown), string(ModeDown))
+	pHalf           = newTokenPattern(string(ModeHalf), string(ModeHalf))
+	pHalfDown       = newTokenPattern(string(ModeHalfDown), string(ModeHalfDown))
+	pEven           = newTokenPattern(string(ModeEven), string(ModeEven))
+	pCeiling        = newTokenPattern(string(ModeCeiling), string(ModeCeiling))
+	pFloor          = newTokenPattern(string(ModeFloor), string(ModeFloor))
 
 	// token patterns
-	pName  = newTokenPattern("name", "[a-z]+([a-z_]*[a-z])?")
-	pIndex = newTokenPattern("index", "[0-9]+")
-	pText  = newTokenPattern("text", "\".*\"")
+	pName       = newTokenPattern("name", "[a-z]+([a-z_]*[a-z])?")
+	pDefinition = newTokenPattern("definition", "(package|import|worksheet|view|enum)")
+	pUnit       = newTokenPattern("unit", "(year|month|week|day|hour|minute|second)s?")
+	pIndex      = newTokenPattern("index", "[0-9]+")
+	pText       = newTokenPattern("text", "\".*\"")
 
 	pNumber               = newTokenPattern("number", "[0-9]+(\\.[0-9]+)?")
 	pNumberWithUnderscore = newTokenPattern("number", "[_0-9]+")
 	pNumberWithDot        = newTokenPattern("number", "\\.[0-9]*")
 )
 
-func (p *parser) parseWorksheets() (map[string]*Definition, error) {
-	wsDefs := make(map[string]*Definition)
+// namespace holds worksheets, views, and enums, possibly parsed from multiple
+// files. Worksheets, views, and enums all share one namespace.
+type namespace struct {
+	defs  map[string]*Definition
+	views map[string]*View
+	enums map[string]*EnumType
 
-	for p.peek(pWorksheet) {
-		def, err := p.parseWorksheet()
+	// kinds maps names to the kind of what they name.
+	kinds map[string]string
+}
+
+func newNamespace() *namespace {
+	return &namespace{
+		defs:  make(map[string]*Definition),
+		views: make(map[string]*View),
+		enums: make(map[string]*EnumType),
+		kinds: make(map[string]string),
+	}
+}
+
+// parseWorksheets parses imports, followed by worksheets, views, and enums up
+// to the end of the input, adding them to the namespace. Rather than stopping
+// at the first error, the parser skips to the next top-level definition, such
+// that all errors are reported at once.
+//
+//  := parsePackage? parseImport* ('worksheet' ... | 'view' ... | 'enum' ...)*
+func (p *parser) parseWorksheets(ns *namespace) ([]*tImport, DefinitionErrors) {
+	var (
+		imports []*tImport
+		errs    DefinitionErrors
+		started bool
+	)
+
+	if p.peek(pPackage) {
+		if err := p.parsePackage(); err != nil {
+			errs.add(p.pos, "%s", err)
+			p.skipDefinition()
+		}
+	}
+
+	for {
+		choice, ok := p.peekWithChoice([]*tokenPattern{
+			pPackage,
+			pImport,
+			pWorksheet,
+			pView,
+			pEnum,
+		}, []string{
+			"package",
+			"import",
+			"worksheet",
+			"view",
+			"enum",
+		})
+		if !ok {
+			if p.next() == "" {
+				break
+			}
+			errs.add(p.pos, "expecting worksheet")
+			p.skipDefinition()
+			continue
+		}
+
+		var (
+			name string
+			err  error
+			pos  = p.pos
+		)
+		switch choice {
+		case "package":
+			errs.add(pos, "package must come first")
+			p.next()
+			p.skipDefinition()
+			continue
+		case "import":
+			var imp *tImport
+			if imp, err = p.parseImport(); err == nil {
+				if started {
+					errs.add(pos, "imports must come before definitions")
+				} else {
+					imports = append(imports, imp)
+				}
+				continue
+			}
+		case "worksheet":
+			var def *Definition
+			if def, err = p.parseWorksheet(); err == nil {
+				name = def.name
+				ns.defs[name] = def
+			}
+		case "view":
+			var view *View
+			if view, err = p.parseView(); err == nil {
+				name = view.name
+				ns.views[name] = view
+			}
+		case "enum":
+			var enum *EnumType
+			if enum, err = p.parseEnum(); err == nil {
+				name = enum.name
+				ns.enums[name] = enum
+			}
+		}
+		if choice != "import" {
+			started = true
+		}
 		if err != nil {
-			return nil, err
+			errs.add(p.pos, "%s", err)
+			p.skipDefinition()
+			continue
 		}
-		if _, exists := wsDefs[def.name]; exists {
-			return nil, fmt.Errorf("multiple worksheets with name %s", def.name)
+
+		if kind, exists := ns.kinds[name]; exists && kind == choice {
+			errs.add(pos, "multiple %ss with name %s", choice, name)
+		} else if exists {
+			article := "a"
+			if kind == "enum" {
+				article = "an"
+			}
+			errs.add(pos, "%s %s has the same name as %s %s", choice, name, article, kind)
+		} else {
+			ns.kinds[name] = choice
 		}
-		wsDefs[def.name] = def
 	}
 
-	return wsDefs, nil
+	return imports, errs
+}
+
+// parsePackage parses the package of the definitions which follow, whose names
+// are qualified by the package, e.g. borrower in package mortgage is named
+// mortgage.borrower.
+//
+//  := 'package' name
+func (p *parser) parsePackage() error {
+	_, err := p.nextAndCheck(pPackage)
+	if err != nil {
+		return err
+	}
+
+	name, err := p.nextAndCheck(pName)
+	if err != nil {
+		return err
+	}
+	p.pkg = name
+
+	return nil
+}
+
+// qualify qualifies the name of a worksheet, view, or enum by the package of
+// the definitions being parsed.
+func (p *parser) qualify(name string) string {
+	if p.pkg == "" {
+		return name
+	}
+	return p.pkg + "." + name
+}
+
+// parseRef parses the rest of a reference to a worksheet, view, or enum by
+// name, returning the qualified name referenced. Unqualified names refer to
+// the package of the definitions being parsed.
+//
+//  := name
+//   | name '.' name
+func (p *parser) parseRef(name string) (string, error) {
+	if !p.peek(pDot) {
+		return p.qualify(name), nil
+	}
+	p.next()
+
+	second, err := p.nextAndCheck(pName)
+	if err != nil {
+		return "", err
+	}
+	return name + "." + second, nil
+}
+
+// parseImport parses an import of the definitions of another file.
+//
+//  := 'import' text
+func (p *parser) parseImport() (*tImport, error) {
+	_, err := p.nextAndCheck(pImport)
+	if err != nil {
+		return nil, err
+	}
+	pos := p.pos
+
+	token, err := p.nextAndCheck(pText)
+	if err != nil {
+		return nil, err
+	}
+	path, err := strconv.Unquote(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tImport{path, pos}, nil
+}
+
+// skipDefinition skips tokens up to the next top-level definition, or the end
+// of the input, to recover from an error.
+func (p *parser) skipDefinition() {
+	for {
+		if p.depth <= 0 && p.peek(pDefinition) {
+			p.depth = 0
+			return
+		}
+		if p.next() == "" {
+			return
+		}
+	}
 }
 
 func (p *parser) parseWorksheet() (*Definition, error) {
@@ -113,12 +359,32 @@ func (p *parser) parseWorksheet() (*Definition, error) {
 	if err != nil {
 		return nil, err
 	}
+	ws.pos, ws.doc = p.pos, p.doc
 
 	name, err := p.nextAndCheck(pName)
 	if err != nil {
 		return nil, err
 	}
-	ws.name = name
+	ws.name = p.qualify(name)
+
+	if p.peek(pImplements) {
+		p.next()
+		for {
+			viewName, err := p.nextAndCheck(pName)
+			if err != nil {
+				return nil, err
+			}
+			viewName, err = p.parseRef(viewName)
+			if err != nil {
+				return nil, err
+			}
+			ws.implements = append(ws.implements, &View{name: viewName})
+			if !p.peek(pComma) {
+				break
+			}
+			p.next()
+		}
+	}
 
 	_, err = p.nextAndCheck(pLacco)
 	if err != nil {
@@ -126,6 +392,18 @@ func (p *parser) parseWorksheet() (*Definition, error) {
 	}
 
 	for !p.peek(pRacco) {
+		if p.peek(pKeyedBy) {
+			if len(ws.keyedBy) != 0 {
+				return nil, fmt.Errorf("%s: multiple keyed_by", ws.name)
+			}
+			keyedBy, err := p.parseKeyedBy()
+			if err != nil {
+				return nil, err
+			}
+			ws.keyedBy = keyedBy
+			continue
+		}
+
 		field, err := p.parseField()
 		if err != nil {
 			return nil, err
@@ -141,11 +419,155 @@ func (p *parser) parseWorksheet() (*Definition, error) {
 	return &ws, nil
 }
 
+// parseView parses a view, i.e. a set of fields which worksheets implementing
+// the view must have.
+//
+//  := 'view' name '{' (name type)* '}'
+func (p *parser) parseView() (*View, error) {
+	view := View{
+		fieldsByName: make(map[string]*Field),
+	}
+
+	_, err := p.nextAndCheck(pView)
+	if err != nil {
+		return nil, err
+	}
+	view.pos = p.pos
+
+	name, err := p.nextAndCheck(pName)
+	if err != nil {
+		return nil, err
+	}
+	view.name = p.qualify(name)
+
+	_, err = p.nextAndCheck(pLacco)
+	if err != nil {
+		return nil, err
+	}
+
+	for !p.peek(pRacco) {
+		fieldName, err := p.nextAndCheck(pName)
+		if err != nil {
+			return nil, err
+		}
+		pos, doc := p.pos, p.doc
+		typ, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := view.fieldsByName[fieldName]; exists {
+			return nil, fmt.Errorf("%s.%s: multiple fields named %s", view.name, fieldName, fieldName)
+		}
+		field := &Field{
+			name: fieldName,
+			pos:  pos,
+			doc:  doc,
+			typ:  typ,
+		}
+		view.fields = append(view.fields, field)
+		view.fieldsByName[fieldName] = field
+	}
+	p.next()
+
+	return &view, nil
+}
+
+// parseEnum parses an enum, i.e. a list of allowed text values.
+//
+//  := 'enum' name '{' text (',' text)* ','? '}'
+func (p *parser) parseEnum() (*EnumType, error) {
+	_, err := p.nextAndCheck(pEnum)
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := p.nextAndCheck(pName)
+	if err != nil {
+		return nil, err
+	}
+	enum := EnumType{
+		name: p.qualify(name),
+	}
+
+	_, err = p.nextAndCheck(pLacco)
+	if err != nil {
+		return nil, err
+	}
+
+	for !p.peek(pRacco) {
+		token, err := p.nextAndCheck(pText)
+		if err != nil {
+			return nil, err
+		}
+		value, err := strconv.Unquote(token)
+		if err != nil {
+			return nil, err
+		}
+		if enum.has(value) {
+			return nil, fmt.Errorf("%s: value %s listed more than once", enum.name, token)
+		}
+		enum.values = append(enum.values, value)
+		if !p.peek(pComma) {
+			break
+		}
+		p.next()
+	}
+
+	_, err = p.nextAndCheck(pRacco)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(enum.values) == 0 {
+		return nil, fmt.Errorf("%s: enum must list at least one value", enum.name)
+	}
+
+	return &enum, nil
+}
+
+// parseKeyedBy parses the key of a worksheet.
+//
+//  := 'keyed_by' 'identity'
+//   | 'keyed_by' '{' name+ '}'
+func (p *parser) parseKeyedBy() ([]string, error) {
+	_, err := p.nextAndCheck(pKeyedBy)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek(pIdentity) {
+		p.next()
+		return []string{"id"}, nil
+	}
+
+	_, err = p.nextAndCheck(pLacco)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for !p.peek(pRacco) {
+		name, err := p.nextAndCheck(pName)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	p.next()
+
+	if len(names) == 0 {
+		return nil, fmt.Errorf("keyed_by must list at least one field")
+	}
+
+	return names, nil
+}
+
 func (p *parser) parseField() (*Field, error) {
 	sIndex, err := p.nextAndCheck(pIndex)
 	if err != nil {
 		return nil, err
 	}
+	pos, doc := p.pos, p.doc
 	index, err := strconv.Atoi(sIndex)
 	if err != nil {
 		// unexpected since sIndex should conform to pIndex
@@ -169,48 +591,117 @@ func (p *parser) parseField() (*Field, error) {
 
 	var computedBy expression
 	if p.peek(pComputedBy) {
-		_, err = p.nextAndCheck(pComputedBy)
+		computedBy, err = p.parseBlock(pComputedBy)
 		if err != nil {
 			return nil, err
 		}
+	}
 
-		_, err = p.nextAndCheck(pLacco)
+	var constrainedBy expression
+	if p.peek(pConstrainedBy) {
+		constrainedBy, err = p.parseBlock(pConstrainedBy)
 		if err != nil {
 			return nil, err
 		}
+	}
 
-		computedBy, err = p.parseStatement()
-		if err != nil {
-			return nil, err
+	f := &Field{
+		index:         index,
+		name:          name,
+		pos:           pos,
+		doc:           doc,
+		typ:           typ,
+		computedBy:    computedBy,
+		constrainedBy: constrainedBy,
+	}
+
+	return f, nil
+}
+
+// parseBlock parses a keyword introducing a block, e.g. computed_by, followed
+// by statements in curly braces.
+//
+//  := keyword '{' parseStatements
+func (p *parser) parseBlock(keyword *tokenPattern) (expression, error) {
+	_, err := p.nextAndCheck(keyword)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = p.nextAndCheck(pLacco)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := p.parseStatements()
+	if err != nil {
+		return nil, err
+	}
+
+	if !isTerminating(block) {
+		return nil, fmt.Errorf("missing return")
+	}
+
+	// Single statement blocks are represented by the statement itself.
+	if len(block.stmts) == 1 {
+		switch stmt := block.stmts[0].(type) {
+		case *tExternal:
+			return stmt, nil
+		case *tReturn:
+			return stmt, nil
 		}
+	}
 
-		_, err = p.nextAndCheck(pRacco)
+	return block, nil
+}
+
+// parseStatements parses the statements of a block up to its closing curly
+// brace, with their own scope of local variables.
+//
+//  := parseStatement* '}'
+func (p *parser) parseStatements() (*tBlock, error) {
+	p.scopes = append(p.scopes, make(map[string]bool))
+	defer func() {
+		p.scopes = p.scopes[:len(p.scopes)-1]
+	}()
+
+	block := &tBlock{}
+	for !p.peek(pRacco) {
+		if len(block.stmts) != 0 && isTerminating(block.stmts[len(block.stmts)-1]) {
+			return nil, fmt.Errorf("unreachable statement after return")
+		}
+		stmt, err := p.parseStatement()
 		if err != nil {
 			return nil, err
 		}
+		if _, ok := stmt.(*tExternal); ok && (len(p.scopes) != 1 || len(block.stmts) != 0) {
+			return nil, fmt.Errorf("external must be the only statement")
+		}
+		block.stmts = append(block.stmts, stmt)
 	}
+	p.next()
 
-	f := &Field{
-		index:      index,
-		name:       name,
-		typ:        typ,
-		computedBy: computedBy,
-	}
-
-	return f, nil
+	return block, nil
 }
 
 // parseStatement
 //
 //  := 'external'
-//   | return parseExpression
-func (p *parser) parseStatement() (expression, error) {
+//   | 'return' parseExpression
+//   | parseIf
+//   | name ':=' parseExpression
+//   | name '=' parseExpression
+func (p *parser) parseStatement() (statement, error) {
 	choice, ok := p.peekWithChoice([]*tokenPattern{
 		pExternal,
 		pReturn,
+		pIf,
+		pName,
 	}, []string{
 		"external",
 		"return",
+		"if",
+		"assign",
 	})
 	if !ok {
 		return nil, fmt.Errorf("expecting statement")
@@ -228,16 +719,128 @@ func (p *parser) parseStatement() (expression, error) {
 		}
 		return &tReturn{expr}, nil
 
+	case "if":
+		return p.parseIf()
+
+	case "assign":
+		name := p.next()
+		op, ok := p.peekWithChoice([]*tokenPattern{
+			pDeclare,
+			pAssign,
+		}, []string{
+			":=",
+			"=",
+		})
+		if !ok {
+			return nil, fmt.Errorf("expected := or =, found %s", p.next())
+		}
+		p.next()
+
+		// The expression is parsed first, such that in `x := x + 1`, the
+		// right-hand side x refers to the field x.
+		expr, err := p.parseExpression(true)
+		if err != nil {
+			return nil, err
+		}
+
+		scope := p.scopes[len(p.scopes)-1]
+		if op == ":=" {
+			if p.isLocal(name) {
+				return nil, fmt.Errorf("%s already declared", name)
+			}
+			scope[name] = true
+		} else if !p.isLocal(name) {
+			return nil, fmt.Errorf("assignment to undeclared %s", name)
+		}
+		return &tAssign{name, expr}, nil
+
 	default:
 		panic(fmt.Sprintf("nextAndChoice returned '%s'", choice))
 	}
 }
 
+// parseIf
+//
+//  := 'if' parseExpression '{' parseStatements
+//   | 'if' parseExpression '{' parseStatements 'else' '{' parseStatements
+//   | 'if' parseExpression '{' parseStatements 'else' parseIf
+func (p *parser) parseIf() (*tIf, error) {
+	_, err := p.nextAndCheck(pIf)
+	if err != nil {
+		return nil, err
+	}
+
+	cond, err := p.parseExpression(true)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = p.nextAndCheck(pLacco)
+	if err != nil {
+		return nil, err
+	}
+	then, err := p.parseStatements()
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.peek(pElse) {
+		return &tIf{cond, then, nil}, nil
+	}
+	p.next()
+
+	var otherwise statement
+	if p.peek(pIf) {
+		otherwise, err = p.parseIf()
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		_, err = p.nextAndCheck(pLacco)
+		if err != nil {
+			return nil, err
+		}
+		otherwise, err = p.parseStatements()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &tIf{cond, then, otherwise}, nil
+}
+
+// isLocal reports whether name is a local variable in scope.
+func (p *parser) isLocal(name string) bool {
+	for _, scope := range p.scopes {
+		if scope[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// isTerminating reports whether the statement always returns, i.e. it is a
+// return, an if with an else whose branches are terminating, or a block
+// ending with a terminating statement.
+func isTerminating(stmt statement) bool {
+	switch s := stmt.(type) {
+	case *tExternal, *tReturn:
+		return true
+	case *tIf:
+		return s.otherwise != nil && isTerminating(s.then) && isTerminating(s.otherwise)
+	case *tBlock:
+		return len(s.stmts) != 0 && isTerminating(s.stmts[len(s.stmts)-1])
+	default:
+		return false
+	}
+}
+
 // parseExpression
 //
 //  := parseLiteral
 //   | var
 //   | exp (+ -  * /) exp
+//   | exp unit
 func (p *parser) parseExpression(withOp bool) (expression, error) {
 	choice, ok := p.peekWithChoice([]*tokenPattern{
 		pUndefined,
@@ -248,6 +851,9 @@ func (p *parser) parseExpression(withOp bool) (expression, error) {
 		pNumberWithUnderscore,
 		pMinus,
 		pText,
+		pDate,
+		pTime,
+		pTuple,
 		pName,
 		pLparen,
 		pNot,
@@ -260,6 +866,9 @@ func (p *parser) parseExpression(withOp bool) (expression, error) {
 		"literal",
 		"literal",
 		"literal",
+		"date",
+		"literal",
+		"literal",
 		"var",
 		"paren",
 		"unop",
@@ -268,6 +877,12 @@ func (p *parser) parseExpression(withOp bool) (expression, error) {
 		return nil, fmt.Errorf("expecting expression")
 	}
 
+	// date, time, and tuple are conversions or literals when followed by (,
+	// and otherwise refer to fields so named.
+	if (p.peek(pDate) || p.peek(pTime) || p.peek(pTuple)) && p.peekSecond() != "(" {
+		choice = "var"
+	}
+
 	// first
 	var first expression
 	switch choice {
@@ -278,9 +893,34 @@ func (p *parser) parseExpression(withOp bool) (expression, error) {
 		}
 		first = val.(expression)
 
+	case "date":
+		expr, err := p.parseDate()
+		if err != nil {
+			return nil, err
+		}
+		first = expr
+
 	case "var":
 		token := p.next()
-		first = &tVar{token}
+		if p.peek(pLparen) {
+			call, err := p.parseCall(token)
+			if err != nil {
+				return nil, err
+			}
+			first = call
+		} else if p.isLocal(token) {
+			first = &tLocal{token}
+		} else {
+			first = &tVar{token}
+		}
+		for p.peek(pDot) {
+			p.next()
+			field, err := p.nextAndCheck(pName)
+			if err != nil {
+				return nil, err
+			}
+			first = &tSelector{first, field}
+		}
 
 	case "paren":
 		p.next()
@@ -316,6 +956,12 @@ func (p *parser) parseExpression(withOp bool) (expression, error) {
 		panic(fmt.Sprintf("nextAndChoice returned '%s'", choice))
 	}
 
+	// duration?
+	if p.peekUnit() {
+		unit := p.next()
+		first = &tDuration{first, durationUnits[unit]}
+	}
+
 	if !withOp {
 		return first, nil
 	}
@@ -340,8 +986,14 @@ func (p *parser) parseExpression(withOp bool) (expression, error) {
 			pMinus,
 			pMult,
 			pDiv,
+			pIntDiv,
+			pMod,
 			pEqual,
 			pNotEqual,
+			pLess,
+			pLessOrEqual,
+			pGreater,
+			pGreaterOrEqual,
 			pAnd,
 			pOr,
 		}, []string{
@@ -349,8 +1001,14 @@ func (p *parser) parseExpression(withOp bool) (expression, error) {
 			string(opMinus),
 			string(opMult),
 			string(opDiv),
+			string(opIntDiv),
+			string(opMod),
 			string(opEqual),
 			string(opNotEqual),
+			string(opLess),
+			string(opLessOrEqual),
+			string(opGreater),
+			string(opGreaterOrEqual),
 			string(opAnd),
 			string(opOr),
 		})
@@ -389,14 +1047,20 @@ func (p *parser) parseExpression(withOp bool) (expression, error) {
 }
 
 var opPrecedence = map[tOp]int{
-	opAnd:      1,
-	opOr:       1,
-	opEqual:    2,
-	opNotEqual: 2,
-	opPlus:     3,
-	opMinus:    3,
-	opMult:     4,
-	opDiv:      5,
+	opAnd:            1,
+	opOr:             1,
+	opEqual:          2,
+	opNotEqual:       2,
+	opLess:           2,
+	opLessOrEqual:    2,
+	opGreater:        2,
+	opGreaterOrEqual: 2,
+	opPlus:           3,
+	opMinus:          3,
+	opMult:           4,
+	opIntDiv:         4,
+	opMod:            4,
+	opDiv:            5,
 }
 
 // foldExprs folds expressions separated by operators by respecting the
@@ -457,25 +1121,163 @@ folding:
 	}
 }
 
+// parseDate parses either a date literal, or the conversion of a time to a
+// date in a specific time zone.
+//
+//  := 'date' '(' text ')'
+//   | 'date' '(' parseExpression ',' parseExpression ')'
+func (p *parser) parseDate() (expression, error) {
+	if _, err := p.nextAndCheck(pDate); err != nil {
+		return nil, err
+	}
+	if _, err := p.nextAndCheck(pLparen); err != nil {
+		return nil, err
+	}
+
+	first, err := p.parseExpression(true)
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.peek(pComma) {
+		if _, err := p.nextAndCheck(pRparen); err != nil {
+			return nil, err
+		}
+		text, ok := first.(*Text)
+		if !ok {
+			return nil, fmt.Errorf("date literal must be text")
+		}
+		date, err := newDateFromString(text.value)
+		if err != nil {
+			return nil, err
+		}
+		return date.(expression), nil
+	}
+
+	p.next()
+	tz, err := p.parseExpression(true)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.nextAndCheck(pRparen); err != nil {
+		return nil, err
+	}
+	return &tDateOf{first, tz}, nil
+}
+
+// parseCall parses a call to a builtin, the name of which has already been
+// consumed.
+//
+//  := '(' ')'
+//   | '(' parseExpression (',' parseExpression)* ')'
+//   | '(' parseExpression ',' mode ',' scale ')' // round only
+func (p *parser) parseCall(name string) (expression, error) {
+	b, ok := builtins[name]
+	if !ok && name != "round" {
+		return nil, fmt.Errorf("unknown function %s", name)
+	}
+
+	if _, err := p.nextAndCheck(pLparen); err != nil {
+		return nil, err
+	}
+
+	if name == "round" {
+		arg, err := p.parseExpression(true)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.nextAndCheck(pComma); err != nil {
+			return nil, err
+		}
+		round, err := p.parseRoundingModeAndScale(pComma)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.nextAndCheck(pRparen); err != nil {
+			return nil, err
+		}
+		if num, ok := arg.(Value); ok {
+			if _, ok := num.(*Undefined); !ok {
+				if _, ok := num.(*Number); !ok {
+					return nil, fmt.Errorf("round: argument 1 must be number, found %s", num.Type())
+				}
+			}
+		}
+		return &tCall{name, []expression{arg}, round}, nil
+	}
+
+	var args []expression
+	for !p.peek(pRparen) {
+		if len(args) != 0 {
+			if _, err := p.nextAndCheck(pComma); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.parseExpression(true)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	p.next()
+
+	if err := b.checkArity(name, len(args)); err != nil {
+		return nil, err
+	}
+
+	// Literal arguments can be checked right away.
+	for i, arg := range args {
+		if value, ok := arg.(Value); ok {
+			if _, ok := value.(*Undefined); ok {
+				continue
+			}
+			if err := b.checkArg(name, i, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &tCall{name, args, nil}, nil
+}
+
 func (p *parser) parseRound() (*tRound, error) {
 	if _, err := p.nextAndCheck(pRound); err != nil {
 		return nil, err
 	}
+	return p.parseRoundingModeAndScale(nil)
+}
 
+// parseRoundingModeAndScale parses a rounding mode followed by a scale, with
+// an optional separator in between, e.g. `up 2` or `up, 2`.
+func (p *parser) parseRoundingModeAndScale(separator *tokenPattern) (*tRound, error) {
 	mode, ok := p.peekWithChoice([]*tokenPattern{
 		pUp,
 		pDown,
+		pCeiling,
+		pFloor,
 		pHalf,
+		pHalfDown,
+		pEven,
 	}, []string{
 		string(ModeUp),
 		string(ModeDown),
+		string(ModeCeiling),
+		string(ModeFloor),
 		string(ModeHalf),
+		string(ModeHalfDown),
+		string(ModeEven),
 	})
 	if !ok {
-		return nil, fmt.Errorf("expecting rounding mode (up, down, or half)")
+		return nil, fmt.Errorf("expecting rounding mode (up, down, ceiling, floor, half, half_down, or even)")
 	}
 	p.next()
 
+	if separator != nil {
+		if _, err := p.nextAndCheck(separator); err != nil {
+			return nil, err
+		}
+	}
+
 	sIndex, err := p.nextAndCheck(pIndex)
 	if err != nil {
 		return nil, err
@@ -515,6 +1317,49 @@ func (p *parser) parseType() (Type, error) {
 			return &tBoolType{}, nil
 		case "undefined":
 			return &tUndefinedType{}, nil
+		case "date":
+			return &tDateType{}, nil
+		case "time":
+			return &tTimeType{}, nil
+		case "tuple":
+			_, err := p.nextAndCheck(pLbracket)
+			if err != nil {
+				return nil, err
+			}
+			var elementTypes []Type
+			for {
+				elementType, err := p.parseType()
+				if err != nil {
+					return nil, err
+				}
+				if !isBaseType(elementType) {
+					return nil, fmt.Errorf("tuple cannot contain %s", elementType)
+				}
+				elementTypes = append(elementTypes, elementType)
+				if !p.peek(pComma) {
+					break
+				}
+				p.next()
+			}
+			_, err = p.nextAndCheck(pRbracket)
+			if err != nil {
+				return nil, err
+			}
+			return &TupleType{elementTypes}, nil
+		case "map":
+			_, err := p.nextAndCheck(pLbracket)
+			if err != nil {
+				return nil, err
+			}
+			elementType, err := p.parseType()
+			if err != nil {
+				return nil, err
+			}
+			_, err = p.nextAndCheck(pRbracket)
+			if err != nil {
+				return nil, err
+			}
+			return &MapType{elementType}, nil
 		case "number":
 			_, err := p.nextAndCheck(pLbracket)
 			if err != nil {
@@ -535,6 +1380,10 @@ func (p *parser) parseType() (Type, error) {
 			}
 			return &tNumberType{scale}, nil
 		default:
+			name, err = p.parseRef(name)
+			if err != nil {
+				return nil, err
+			}
 			return &Definition{name: name}, nil
 		}
 
@@ -568,6 +1417,45 @@ func (p *parser) parseLiteral() (Value, error) {
 		return &Bool{true}, nil
 	case "false":
 		return &Bool{false}, nil
+	case "date", "time":
+		if _, err := p.nextAndCheck(pLparen); err != nil {
+			return nil, err
+		}
+		text, err := p.nextAndCheck(pText)
+		if err != nil {
+			return nil, err
+		}
+		value, err := strconv.Unquote(text)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.nextAndCheck(pRparen); err != nil {
+			return nil, err
+		}
+		if token == "date" {
+			return newDateFromString(value)
+		}
+		return newTimeFromString(value)
+	case "tuple":
+		if _, err := p.nextAndCheck(pLparen); err != nil {
+			return nil, err
+		}
+		var values []Value
+		for {
+			value, err := p.parseLiteral()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+			if !p.peek(pComma) {
+				break
+			}
+			p.next()
+		}
+		if _, err := p.nextAndCheck(pRparen); err != nil {
+			return nil, err
+		}
+		return NewTuple(values...)
 	case "-":
 		negNumber = true
 		token, err = p.nextAndCheck(pNumber)
@@ -642,46 +1530,131 @@ func (p *parser) nextAndCheck(expected *tokenPattern) (string, error) {
 }
 
 var tokensToCombine = map[string]string{
+	":": "=",
 	"=": "=",
 	"!": "=",
 	"&": "&",
 	"|": "|",
+	"<": "=",
+	">": "=",
+}
+
+// token is a token read, along with its position, and documentation.
+type token struct {
+	text string
+	pos  scanner.Position
+	doc  string
 }
 
 func (p *parser) next() string {
+	var tok token
 	if len(p.toks) == 0 {
-		p.s.Scan()
-		token := p.s.TokenText()
+		tok = p.scan()
+	} else {
+		tok = p.toks[len(p.toks)-1]
+		p.toks = p.toks[:len(p.toks)-1]
+	}
 
-		second, ok := tokensToCombine[token]
-		if !ok {
-			return token
+	p.pos = tok.pos
+	p.doc = tok.doc
+	switch tok.text {
+	case "{":
+		p.depth++
+	case "}":
+		p.depth--
+	}
+	return tok.text
+}
+
+// unread puts back the last token read, such that it is read again.
+func (p *parser) unread(text string) {
+	p.toks = append(p.toks, token{text, p.pos, p.doc})
+	switch text {
+	case "{":
+		p.depth--
+	case "}":
+		p.depth++
+	}
+}
+
+func (p *parser) scan() token {
+	first := p.scanSkippingComments()
+
+	second, ok := tokensToCombine[first.text]
+	if !ok {
+		return first
+	}
+
+	next := p.scanSkippingComments()
+	if next.text == second && first.pos.Line == next.pos.Line && first.pos.Column == next.pos.Column-1 {
+		return token{first.text + second, first.pos, first.doc}
+	}
+	p.toks = append(p.toks, next)
+	return first
+}
+
+// scanSkippingComments scans the next token, skipping comments. The line
+// comments immediately preceding the token, with no blank line in between,
+// form its documentation, unless they follow another token on the same line.
+func (p *parser) scanSkippingComments() token {
+	var (
+		doc       []string
+		docStart  int
+		docEnd    int
+		afterLine = p.line
+	)
+	for {
+		tok := p.s.Scan()
+		text, pos := p.s.TokenText(), p.s.Position
+		if tok != scanner.Comment {
+			p.line = pos.Line
+			if len(doc) == 0 || docEnd != pos.Line-1 || docStart == afterLine {
+				return token{text, pos, ""}
+			}
+			return token{text, pos, strings.Join(doc, "\n")}
 		}
 
-		first := token
-		firstPos := p.s.Position
-		p.s.Scan()
-		seconPos := p.s.Position
-		token = p.s.TokenText()
-		if token == second && firstPos.Line == seconPos.Line && firstPos.Column == seconPos.Column-1 {
-			return first + second
+		if !strings.HasPrefix(text, "//") {
+			doc = nil
+			continue
 		}
-		p.toks = append(p.toks, token)
-		return first
-	} else {
-		token := p.toks[len(p.toks)-1]
-		p.toks = p.toks[:len(p.toks)-1]
-		return token
+		if len(doc) == 0 || docEnd != pos.Line-1 {
+			doc, docStart = nil, pos.Line
+		}
+		line := strings.TrimPrefix(strings.TrimPrefix(text, "//"), " ")
+		doc = append(doc, strings.TrimRight(line, " \t\r"))
+		docEnd = pos.Line
 	}
 }
 
 func (p *parser) peek(maybe *tokenPattern) bool {
 	token := p.next()
-	p.toks = append(p.toks, token)
+	p.unread(token)
 
 	return maybe.re.MatchString(token)
 }
 
+// peekUnit peeks for a duration unit. A name like a unit followed by := or =
+// starts the next statement, e.g. `days := 5`, and is not a unit.
+func (p *parser) peekUnit() bool {
+	if !p.peek(pUnit) {
+		return false
+	}
+	after := p.peekSecond()
+	return after != ":=" && after != "="
+}
+
+// peekSecond peeks at the token following the next one.
+func (p *parser) peekSecond() string {
+	first := p.next()
+	pos, doc := p.pos, p.doc
+	second := p.next()
+	p.unread(second)
+	p.pos, p.doc = pos, doc
+	p.unread(first)
+	return second
+}
+
 // peekWithChoice peeks, and matches against a set of possible tokens. When a
 // match is found, it returns the choice in the choice array corresponding to
 // the index of the token in the maybes array.
@@ -694,7 +1667,7 @@ func (p *parser) peekWithChoice(maybes []*tokenPattern, choices []string) (strin
 	}
 
 	token := p.next()
-	p.toks = append(p.toks, token)
+	p.unread(token)
 
 	for index, maybe := range maybes {
 		if maybe.re.MatchString(token) {
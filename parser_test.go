@@ -81,6 +81,107 @@ func (s *Zuite) TestParser_parseStatement() {
 	}
 }
 
+func (s *Zuite) TestParser_parseBlock() {
+	var (
+		vOne = &Number{1, &tNumberType{0}}
+		vTwo = &Number{2, &tNumberType{0}}
+	)
+	cases := map[string]expression{
+		`{ external }`:    &tExternal{},
+		`{ return true }`: &tReturn{&Bool{true}},
+		`{
+			x := a
+			return x
+		}`: &tBlock{[]statement{
+			&tAssign{"x", &tVar{"a"}},
+			&tReturn{&tLocal{"x"}},
+		}},
+		`{
+			x := x + 1
+			x = x + 1
+			return x
+		}`: &tBlock{[]statement{
+			&tAssign{"x", &tBinop{opPlus, &tVar{"x"}, vOne, nil}},
+			&tAssign{"x", &tBinop{opPlus, &tLocal{"x"}, vOne, nil}},
+			&tReturn{&tLocal{"x"}},
+		}},
+		`{
+			n := a
+			years := n + 1
+			days := (n) days
+			return years
+		}`: &tBlock{[]statement{
+			&tAssign{"n", &tVar{"a"}},
+			&tAssign{"years", &tBinop{opPlus, &tLocal{"n"}, vOne, nil}},
+			&tAssign{"days", &tDuration{&tLocal{"n"}, durationUnits["days"]}},
+			&tReturn{&tLocal{"years"}},
+		}},
+		`{
+			if a {
+				y := 1
+				return y
+			} else if b {
+				return 2
+			} else {
+				return y
+			}
+		}`: &tBlock{[]statement{
+			&tIf{
+				&tVar{"a"},
+				&tBlock{[]statement{
+					&tAssign{"y", vOne},
+					&tReturn{&tLocal{"y"}},
+				}},
+				&tIf{
+					&tVar{"b"},
+					&tBlock{[]statement{&tReturn{vTwo}}},
+					&tBlock{[]statement{&tReturn{&tVar{"y"}}}},
+				},
+			},
+		}},
+		`{
+			if a {
+				return 1
+			}
+			return 2
+		}`: &tBlock{[]statement{
+			&tIf{&tVar{"a"}, &tBlock{[]statement{&tReturn{vOne}}}, nil},
+			&tReturn{vTwo},
+		}},
+	}
+	for input, expected := range cases {
+		p := newParser(strings.NewReader("computed_by " + input))
+		actual, err := p.parseBlock(pComputedBy)
+		require.NoError(s.T(), err, input)
+		require.Equal(s.T(), "", p.next(), "%s should have reached eof", input)
+		assert.Equal(s.T(), expected, actual, input)
+	}
+}
+
+func (s *Zuite) TestParser_parseBlockErrors() {
+	cases := map[string]string{
+		`{}`:                                    `missing return`,
+		`{ x := 1 }`:                            `missing return`,
+		`{ if a { return 1 } }`:                 `missing return`,
+		`{ if a { return 1 } else { x := 1 } }`: `missing return`,
+		`{ return 1 return 2 }`:                 `unreachable statement after return`,
+		`{ if a { return 1 } else { return 2 } x := 1 }`: `unreachable statement after return`,
+		`{ x := 1 x := 2 return x }`:                     `x already declared`,
+		`{ x := 1 if a { x := 2 } return x }`:            `x already declared`,
+		`{ x = 1 return x }`:                             `assignment to undeclared x`,
+		`{ if a { x := 1 } x = 2 return x }`:             `assignment to undeclared x`,
+		`{ x + 1 }`:                                      `expected := or =, found +`,
+		`{ x := 1 external }`:                            `external must be the only statement`,
+		`{ if a { external } return 1 }`:                 `external must be the only statement`,
+		`{ if a return 1 }`:                              `expected {, found return`,
+	}
+	for input, expected := range cases {
+		p := newParser(strings.NewReader("computed_by " + input))
+		_, err := p.parseBlock(pComputedBy)
+		assert.EqualError(s.T(), err, expected, input)
+	}
+}
+
 func (s *Zuite) TestParser_parseExpression() {
 	cases := map[string]expression{
 		// literals
@@ -93,9 +194,17 @@ func (s *Zuite) TestParser_parseExpression() {
 		// var
 		`foo`: &tVar{"foo"},
 
+		// date, time, and tuple name fields unless followed by (
+		`date`:         &tVar{"date"},
+		`time.zone`:    &tSelector{&tVar{"time"}, "zone"},
+		`tuple`:        &tVar{"tuple"},
+		`date + 1 day`: &tBinop{opPlus, &tVar{"date"}, &tDuration{&Number{1, &tNumberType{0}}, durationUnits["day"]}, nil},
+
 		// unop and binop
-		`3 + 4`: &tBinop{opPlus, &Number{3, &tNumberType{0}}, &Number{4, &tNumberType{0}}, nil},
-		`!foo`:  &tUnop{opNot, &tVar{"foo"}},
+		`3 + 4`:   &tBinop{opPlus, &Number{3, &tNumberType{0}}, &Number{4, &tNumberType{0}}, nil},
+		`3 % 4`:   &tBinop{opMod, &Number{3, &tNumberType{0}}, &Number{4, &tNumberType{0}}, nil},
+		`3 div 4`: &tBinop{opIntDiv, &Number{3, &tNumberType{0}}, &Number{4, &tNumberType{0}}, nil},
+		`!foo`:    &tUnop{opNot, &tVar{"foo"}},
 
 		// parentheses
 		`(true)`:          &Bool{true},
@@ -159,6 +268,27 @@ func (s *Zuite) TestParser_parseExpression() {
 	}
 }
 
+func (s *Zuite) TestParser_keywordsAsFieldNames() {
+	defs := MustNewDefinitions(strings.NewReader(`worksheet event {
+		1:date date
+		2:time time
+		3:tuple text
+		4:next_day date computed_by { return date + 1 day }
+		5:later time computed_by { return time + 1 hour }
+		6:label text computed_by { return tuple }
+		7:local date computed_by { return date(time, "UTC") }
+	}`))
+
+	ws := defs.MustNewWorksheet("event")
+	ws.MustSet("date", MustNewValue(`date("2018-01-01")`))
+	ws.MustSet("time", MustNewValue(`time("2018-01-01T10:00:00Z")`))
+	ws.MustSet("tuple", NewText("party"))
+	require.Equal(s.T(), `date("2018-01-02")`, ws.MustGet("next_day").String())
+	require.Equal(s.T(), `time("2018-01-01T11:00:00Z")`, ws.MustGet("later").String())
+	require.Equal(s.T(), `"party"`, ws.MustGet("label").String())
+	require.Equal(s.T(), `date("2018-01-01")`, ws.MustGet("local").String())
+}
+
 func (s *Zuite) TestParser_parseExpressionsAndCheckCompute() {
 	// Parsing and evaluating expressions is an easier way to write tests for
 	// operator precedence rules. It's great when things are green... And when
@@ -172,6 +302,15 @@ func (s *Zuite) TestParser_parseExpressionsAndCheckCompute() {
 		`3 + 4 * 5`:   `23`,
 		`3 * 4 + 5`:   `17`,
 		`3 * (4 + 5)`: `27`,
+		`7 % 3`:       `1`,
+		`7 div 2`:     `3`,
+		`1 + 7 % 3`:   `2`,
+		`2 * 7 % 3`:   `2`,
+		`7 % 3 * 2`:   `2`,
+		`1 + 7 div 2`: `4`,
+		`-7 % 3`:      `-1`,
+		`7.5 % 2`:     `1.5`,
+		`7.5 div 2`:   `3`,
 
 		`1.2345 round down 0`: `1`,
 		`1.2345 round down 1`: `1.2`,
@@ -186,6 +325,13 @@ func (s *Zuite) TestParser_parseExpressionsAndCheckCompute() {
 		`1.2345 round up 4`:   `1.2345`,
 		`1.2345 round up 5`:   `1.23450`,
 
+		`-2.5 round ceiling 0`:   `-2`,
+		`-2.5 round floor 0`:     `-3`,
+		`-2.5 round half_down 0`: `-2`,
+		`2.5 round even 0`:       `2`,
+		`3.5 round even 0`:       `4`,
+		`7 / 2 round even 0`:     `4`,
+
 		` 3 * 5  / 4 round down 0`:             `3`,
 		`(3 * 5) / 4 round down 0`:             `3`,
 		` 3 * 5  / 4 round up 0`:               `6`,
@@ -212,6 +358,60 @@ func (s *Zuite) TestParser_parseExpressionsAndCheckCompute() {
 		`false && undefined`:               `false`,
 		`false && 6 / 0 round down 7 == 6`: `false`,
 
+		`3 < 4`:                  `true`,
+		`4 < 4`:                  `false`,
+		`4 <= 4`:                 `true`,
+		`5 <= 4`:                 `false`,
+		`5 > 4`:                  `true`,
+		`4 > 4`:                  `false`,
+		`4 >= 4`:                 `true`,
+		`3 >= 4`:                 `false`,
+		`1.5 < 2`:                `true`,
+		`2 <= 2.000`:             `true`,
+		`2.01 > 2.1`:             `false`,
+		`1.00 == 1`:              `true`,
+		`1 == 1.00`:              `true`,
+		`1.10 != 1.1`:            `false`,
+		`1.01 != 1`:              `true`,
+		`-3 < -2.5`:              `true`,
+		`1 + 2 < 2 * 2`:          `true`,
+		`1 < 2 == true`:          `true`,
+		`1 < 2 && 3 >= 4`:        `false`,
+		`"abc" < "abd"`:          `true`,
+		`"abc" < "ab"`:           `false`,
+		`"" <= ""`:               `true`,
+		`undefined < 5`:          `undefined`,
+		`5 >= undefined`:         `undefined`,
+		`false || undefined > 1`: `undefined`,
+
+		`date("2018-01-31") < date("2018-02-01")`:                     `true`,
+		`date("2018-01-31") + 1 day >= date("2018-02-01")`:            `true`,
+		`time("2018-01-01T10:00:00Z") > time("2018-01-01T09:00:00Z")`: `true`,
+
+		`date("2018-01-31") + 1 month`:        `date("2018-02-28")`,
+		`date("2016-02-29") + 1 year`:         `date("2017-02-28")`,
+		`date("2016-02-29") + 4 years`:        `date("2020-02-29")`,
+		`date("2018-01-01") - 30 days`:        `date("2017-12-02")`,
+		`date("2018-01-01") + 2 weeks`:        `date("2018-01-15")`,
+		`date("2018-01-01") + -1 day`:         `date("2017-12-31")`,
+		`date("2018-01-01") + (2 + 3) days`:   `date("2018-01-06")`,
+		`date("2018-01-01") + undefined`:      `undefined`,
+		`undefined + 5 days`:                  `undefined`,
+		`date("2018-01-01") + undefined days`: `undefined`,
+
+		`time("2018-01-01T10:00:00Z") + 3 hours`:    `time("2018-01-01T13:00:00Z")`,
+		`time("2018-01-01T10:00:00Z") - 90 minutes`: `time("2018-01-01T08:30:00Z")`,
+		`time("2018-01-01T10:00:00Z") + 1 second`:   `time("2018-01-01T10:00:01Z")`,
+		`time("2018-03-31T10:00:00Z") + 1 month`:    `time("2018-04-30T10:00:00Z")`,
+
+		`date("2018-01-01") == date("2018-01-01")`: `true`,
+		`date("2018-01-01") != date("2018-01-02")`: `true`,
+
+		`date(time("2018-01-01T03:00:00Z"), "UTC")`:              `date("2018-01-01")`,
+		`date(time("2018-01-01T03:00:00Z"), "America/New_York")`: `date("2017-12-31")`,
+		`date(time("2018-01-01T03:00:00Z"), "Asia/Tokyo")`:       `date("2018-01-01")`,
+		`date(undefined, "UTC")`:                                 `undefined`,
+
 		// TODO(pascal): work on convoluted examples below
 		// `5 - 1 == 2 * 2 round down 2 round down 0`: `true`,
 	}
@@ -221,12 +421,46 @@ func (s *Zuite) TestParser_parseExpressionsAndCheckCompute() {
 		expr, err := p.parseExpression(true)
 		require.NoError(s.T(), err, input)
 		require.Equal(s.T(), "", p.next(), "%s should have reached eof", input)
-		actual, err := expr.Compute(nil)
+		actual, err := expr.Compute(nil, nil)
 		require.NoError(s.T(), err, input)
 		assert.Equal(s.T(), expected, actual, "%s should equal %s was %s", input, output, actual)
 	}
 }
 
+func (s *Zuite) TestParser_parseAndComputeDateAndTimeErrors() {
+	cases := map[string]string{
+		`date("2018-01-01") + 1 hour`:                           `cannot add hours to date`,
+		`date("2018-01-01") + 1.5 days`:                         `duration must be a whole number, found 1.5`,
+		`date("2018-01-01") * 1 day`:                            `op on duration`,
+		`5 + 1 day`:                                             `duration added to non-date and non-time`,
+		`date(time("2018-01-01T03:00:00Z"), "Nowhere/Special")`: `unknown time zone Nowhere/Special`,
+		`date(date("2018-01-01"), "UTC")`:                       `date conversion on non-time`,
+	}
+	for input, expected := range cases {
+		p := newParser(strings.NewReader(input))
+		expr, err := p.parseExpression(true)
+		require.NoError(s.T(), err, input)
+		_, err = expr.Compute(nil, nil)
+		assert.EqualError(s.T(), err, expected, input)
+	}
+}
+
+func (s *Zuite) TestParser_parseAndComputeComparisonErrors() {
+	cases := map[string]string{
+		`1 < "1"`:                   `cannot compare number[0] and text`,
+		`"a" >= true`:               `cannot compare text and bool`,
+		`true > false`:              `cannot compare bool and bool`,
+		`date("2018-01-01") <= 1.5`: `cannot compare date and number[1]`,
+	}
+	for input, expected := range cases {
+		p := newParser(strings.NewReader(input))
+		expr, err := p.parseExpression(true)
+		require.NoError(s.T(), err, input)
+		_, err = expr.Compute(nil, nil)
+		assert.EqualError(s.T(), err, expected, input)
+	}
+}
+
 func (s *Zuite) TestParser_parseExpressionErrors() {
 	cases := map[string]string{
 		`_1_234`:    `number cannot start with underscore`,
@@ -271,8 +505,15 @@ func (s *Zuite) TestParser_parseType() {
 		`text`:      &tTextType{},
 		`bool`:      &tBoolType{},
 		`number[5]`: &tNumberType{5},
+		`date`:      &tDateType{},
+		`time`:      &tTimeType{},
 		`[]bool`:    &SliceType{&tBoolType{}},
 		`foobar`:    &Definition{name: "foobar"},
+
+		`tuple[text]`:                  &TupleType{[]Type{&tTextType{}}},
+		`tuple[text, number[2], date]`: &TupleType{[]Type{&tTextType{}, &tNumberType{2}, &tDateType{}}},
+		`[]tuple[bool, time]`:          &SliceType{&TupleType{[]Type{&tBoolType{}, &tTimeType{}}}},
+		`map[foobar]`:                  &MapType{&Definition{name: "foobar"}},
 	}
 	for input, expected := range cases {
 		p := newParser(strings.NewReader(input))
@@ -282,6 +523,22 @@ func (s *Zuite) TestParser_parseType() {
 	}
 }
 
+func (s *Zuite) TestParser_parseTypeErrors() {
+	cases := map[string]string{
+		`tuple[]`:               `expecting type`,
+		`tuple[text,]`:          `expecting type`,
+		`tuple[text number[2]]`: `expected ], found number`,
+		`tuple[[]text]`:         `tuple cannot contain []text`,
+		`tuple[tuple[text]]`:    `tuple cannot contain tuple[text]`,
+		`tuple[foobar]`:         `tuple cannot contain foobar`,
+	}
+	for input, expected := range cases {
+		p := newParser(strings.NewReader(input))
+		_, err := p.parseType()
+		assert.EqualError(s.T(), err, expected, input)
+	}
+}
+
 func (s *Zuite) TestTokenizer() {
 	cases := map[string][]string{
 		`worksheet simple {1:full_name text}`: []string{
@@ -323,6 +580,15 @@ func (s *Zuite) TestTokenizer() {
 			"2", "|", "|",
 			"done",
 		},
+		`1<=2<3< =4>=5>6> =done`: []string{
+			"1", "<=",
+			"2", "<",
+			"3", "<", "=",
+			"4", ">=",
+			"5", ">",
+			"6", ">", "=",
+			"done",
+		},
 	}
 	for input, toks := range cases {
 		p := newParser(strings.NewReader(input))
@@ -15,11 +15,49 @@ package worksheets
 import (
 	"fmt"
 	"math"
+	"strings"
 
 	"github.com/stretchr/testify/require"
 	"gopkg.in/mgutz/dat.v2/sqlx-runner"
 )
 
+var refsDefs = MustNewDefinitions(strings.NewReader(`
+worksheet borrower {
+	1:first_name text
+	2:last_name text
+	3:income number[2]
+}
+
+worksheet loan {
+	1:borrower borrower
+	2:co_borrower borrower
+	3:borrower_name text computed_by {
+		return borrower.first_name + " " + borrower.last_name
+	}
+	4:total_income number[2] computed_by {
+		return borrower.income + co_borrower.income
+	}
+}
+
+worksheet application {
+	1:loan loan
+	2:summary text computed_by {
+		return loan.borrower.last_name
+	}
+}
+
+worksheet requires_review {
+	1:notes text
+}
+
+worksheet sign_off {
+	1:requires_review requires_review
+	2:signed_off_version number[0]
+	3:signed_off bool computed_by {
+		return requires_review.version == signed_off_version
+	}
+}`))
+
 func (s *Zuite) TestRefsExample() {
 	ws := defs.MustNewWorksheet("with_refs")
 
@@ -35,6 +73,304 @@ func (s *Zuite) TestRefsErrors_setWithWrongWorksheet() {
 	require.EqualError(s.T(), err, "cannot assign value of type with_refs to field of type simple")
 }
 
+func (s *Zuite) TestRefsSelector() {
+	var (
+		loan     = refsDefs.MustNewWorksheet("loan")
+		borrower = refsDefs.MustNewWorksheet("borrower")
+	)
+
+	// undefined refs yield undefined
+	require.False(s.T(), loan.MustIsSet("borrower_name"))
+
+	borrower.MustSet("first_name", NewText("Alice"))
+	borrower.MustSet("last_name", NewText("Smith"))
+	loan.MustSet("borrower", borrower)
+	require.Equal(s.T(), NewText("Alice Smith"), loan.MustGet("borrower_name"))
+
+	loan.MustUnset("borrower")
+	require.False(s.T(), loan.MustIsSet("borrower_name"))
+}
+
+func (s *Zuite) TestRefsPropagation() {
+	var (
+		app         = refsDefs.MustNewWorksheet("application")
+		loan        = refsDefs.MustNewWorksheet("loan")
+		borrower    = refsDefs.MustNewWorksheet("borrower")
+		coBorrower  = refsDefs.MustNewWorksheet("borrower")
+		anotherLoan = refsDefs.MustNewWorksheet("loan")
+	)
+	app.MustSet("loan", loan)
+	loan.MustSet("borrower", borrower)
+	loan.MustSet("co_borrower", coBorrower)
+	anotherLoan.MustSet("borrower", borrower)
+
+	// edits in the child propagate to all parents
+	borrower.MustSet("first_name", NewText("Alice"))
+	borrower.MustSet("last_name", NewText("Smith"))
+	require.Equal(s.T(), NewText("Alice Smith"), loan.MustGet("borrower_name"))
+	require.Equal(s.T(), NewText("Alice Smith"), anotherLoan.MustGet("borrower_name"))
+
+	// and up the graph, even through a parent's computed field
+	require.Equal(s.T(), NewText("Smith"), app.MustGet("summary"))
+
+	// the same worksheet referenced by two fields
+	loan.MustSet("co_borrower", borrower)
+	borrower.MustSet("income", MustNewValue("100.00"))
+	require.Equal(s.T(), MustNewValue("200.00"), loan.MustGet("total_income"))
+
+	// once no longer referenced, edits do not propagate
+	loan.MustSet("co_borrower", coBorrower)
+	loan.MustUnset("borrower")
+	borrower.MustSet("last_name", NewText("Jones"))
+	require.Equal(s.T(), NewText("Alice Jones"), anotherLoan.MustGet("borrower_name"))
+	require.False(s.T(), loan.MustIsSet("borrower_name"))
+	require.False(s.T(), app.MustIsSet("summary"))
+	require.Len(s.T(), borrower.parents, 1)
+}
+
+func (s *Zuite) TestRefsPropagation_errorLeavesAllWorksheetsUntouched() {
+	defs := MustNewDefinitions(strings.NewReader(`
+	worksheet child {
+		1:name text
+	}
+
+	worksheet parent {
+		1:child child
+		2:initials text computed_by {
+			return substr(child.name, 0, 2)
+		}
+	}`))
+
+	var (
+		parent = defs.MustNewWorksheet("parent")
+		child  = defs.MustNewWorksheet("child")
+	)
+	child.MustSet("name", NewText("Alice"))
+	parent.MustSet("child", child)
+	require.Equal(s.T(), NewText("Al"), parent.MustGet("initials"))
+
+	err := child.Set("name", NewText("A"))
+	require.EqualError(s.T(), err, "substr: range [0, 2) out of bounds of text of length 1")
+	require.Equal(s.T(), NewText("Alice"), child.MustGet("name"))
+	require.Equal(s.T(), NewText("Al"), parent.MustGet("initials"))
+}
+
+func (s *Zuite) TestRefsPropagation_constraintViolationLeavesAllWorksheetsUntouched() {
+	defs := MustNewDefinitions(strings.NewReader(`
+	worksheet child {
+		1:amount number[0]
+	}
+
+	worksheet parent {
+		1:c child
+		2:child_amount number[0] computed_by { return c.amount }
+		3:limit number[0] constrained_by { return child_amount < limit }
+	}`))
+
+	var (
+		parent = defs.MustNewWorksheet("parent")
+		child  = defs.MustNewWorksheet("child")
+	)
+	child.MustSet("amount", MustNewValue("5"))
+	parent.MustSet("c", child)
+	parent.MustSet("limit", MustNewValue("10"))
+
+	err := parent.Set("limit", MustNewValue("3"))
+	require.EqualError(s.T(), err, "parent.limit: constraint not satisfied")
+
+	err = child.Set("amount", MustNewValue("50"))
+	require.Equal(s.T(), &ConstraintError{
+		Worksheet: "parent",
+		Field:     "limit",
+	}, err)
+	require.Equal(s.T(), "5", child.MustGet("amount").String())
+	require.Equal(s.T(), "5", parent.MustGet("child_amount").String())
+	require.Equal(s.T(), "10", parent.MustGet("limit").String())
+	require.Len(s.T(), child.parents, 1)
+}
+
+var multiHopDefs = MustNewDefinitions(strings.NewReader(`
+worksheet c {
+	1:v number[0]
+}
+
+worksheet b {
+	1:c c
+	2:cs []c
+}
+
+worksheet a {
+	1:b b
+	2:v number[0] computed_by { return b.c.v }
+	3:total number[0] computed_by { return sum(b.cs.v) }
+}`))
+
+func (s *Zuite) TestRefsPropagation_multiHop() {
+	var (
+		a = multiHopDefs.MustNewWorksheet("a")
+		b = multiHopDefs.MustNewWorksheet("b")
+		c = multiHopDefs.MustNewWorksheet("c")
+	)
+	c.MustSet("v", MustNewValue("1"))
+	b.MustSet("c", c)
+	a.MustSet("b", b)
+	require.Equal(s.T(), "1", a.MustGet("v").String())
+
+	// b is unchanged, since it has no computed fields, yet a is recomputed
+	c.MustSet("v", MustNewValue("2"))
+	require.Equal(s.T(), "2", a.MustGet("v").String())
+}
+
+func (s *Zuite) TestRefsPropagation_multiHopThroughSlices() {
+	var (
+		a  = multiHopDefs.MustNewWorksheet("a")
+		b  = multiHopDefs.MustNewWorksheet("b")
+		c1 = multiHopDefs.MustNewWorksheet("c")
+		c2 = multiHopDefs.MustNewWorksheet("c")
+	)
+	c1.MustSet("v", MustNewValue("1"))
+	c2.MustSet("v", MustNewValue("2"))
+	b.MustAppend("cs", c1)
+	b.MustAppend("cs", c2)
+	a.MustSet("b", b)
+	require.Equal(s.T(), "3", a.MustGet("total").String())
+
+	c2.MustSet("v", MustNewValue("5"))
+	require.Equal(s.T(), "6", a.MustGet("total").String())
+}
+
+func (s *Zuite) TestRefsPropagation_multiHopWithCycles() {
+	defs := MustNewDefinitions(strings.NewReader(`
+	worksheet node {
+		1:next node
+		2:v number[0]
+		3:next_next_v number[0] computed_by { return next.next.v }
+	}`))
+
+	var (
+		x = defs.MustNewWorksheet("node")
+		y = defs.MustNewWorksheet("node")
+		z = defs.MustNewWorksheet("node")
+	)
+	x.MustSet("next", y)
+	y.MustSet("next", z)
+	z.MustSet("next", x)
+	z.MustSet("v", MustNewValue("1"))
+	require.Equal(s.T(), "1", x.MustGet("next_next_v").String())
+
+	z.MustSet("v", MustNewValue("2"))
+	require.Equal(s.T(), "2", x.MustGet("next_next_v").String())
+	require.False(s.T(), y.MustIsSet("next_next_v"))
+}
+
+func (s *DbZuite) TestRefsPropagation_versionBumpOnUpdate() {
+	var (
+		ws     = refsDefs.MustNewWorksheet("sign_off")
+		review = refsDefs.MustNewWorksheet("requires_review")
+	)
+	ws.MustSet("requires_review", review)
+	ws.MustSet("signed_off_version", MustNewValue("1"))
+	require.Equal(s.T(), NewBool(true), ws.MustGet("signed_off"))
+
+	s.MustRunTransaction(func(tx *runner.Tx) error {
+		session := s.store.Open(tx)
+		return session.Save(ws)
+	})
+
+	// modifying the reviewed sheet nullifies the sign off once saved
+	review.MustSet("notes", NewText("changed after sign off"))
+	s.MustRunTransaction(func(tx *runner.Tx) error {
+		session := s.store.Open(tx)
+		return session.Update(ws)
+	})
+	require.Equal(s.T(), 2, review.Version())
+	require.Equal(s.T(), NewBool(false), ws.MustGet("signed_off"))
+	require.Empty(s.T(), ws.diff())
+}
+
+func (s *DbZuite) TestRefsPropagation_afterLoad() {
+	var (
+		store  = NewStore(refsDefs)
+		loanId string
+	)
+	s.MustRunTransaction(func(tx *runner.Tx) error {
+		loan := refsDefs.MustNewWorksheet("loan")
+		borrower := refsDefs.MustNewWorksheet("borrower")
+		borrower.MustSet("first_name", NewText("Alice"))
+		borrower.MustSet("last_name", NewText("Smith"))
+		loan.MustSet("borrower", borrower)
+		loanId = loan.Id()
+
+		return store.Open(tx).Save(loan)
+	})
+
+	var fresh *Worksheet
+	s.MustRunTransaction(func(tx *runner.Tx) error {
+		var err error
+		fresh, err = store.Open(tx).Load(loanId)
+		return err
+	})
+	require.Equal(s.T(), NewText("Alice Smith"), fresh.MustGet("borrower_name"))
+
+	borrower := fresh.MustGet("borrower").(*Worksheet)
+	borrower.MustSet("last_name", NewText("Jones"))
+	require.Equal(s.T(), NewText("Alice Jones"), fresh.MustGet("borrower_name"))
+}
+
+func (s *DbZuite) TestRefsPropagation_sliceElementsAfterLoad() {
+	var wsId string
+	s.MustRunTransaction(func(tx *runner.Tx) error {
+		ws := defs.MustNewWorksheet("with_slice_of_refs")
+		ws.MustAppend("many_simples", defs.MustNewWorksheet("simple"))
+		wsId = ws.Id()
+
+		return s.store.Open(tx).Save(ws)
+	})
+
+	var fresh *Worksheet
+	s.MustRunTransaction(func(tx *runner.Tx) error {
+		var err error
+		fresh, err = s.store.Open(tx).Load(wsId)
+		return err
+	})
+
+	simple := fresh.MustGetSlice("many_simples")[0].(*Worksheet)
+	require.Equal(s.T(), map[*Worksheet]map[int]int{
+		fresh: {fresh.def.fieldsByName["many_simples"].index: 1},
+	}, simple.parents)
+}
+
+func (s *DbZuite) TestRefsPropagation_multiHopAfterLoad() {
+	var (
+		store = NewStore(multiHopDefs)
+		aId   string
+	)
+	s.MustRunTransaction(func(tx *runner.Tx) error {
+		a := multiHopDefs.MustNewWorksheet("a")
+		b := multiHopDefs.MustNewWorksheet("b")
+		c := multiHopDefs.MustNewWorksheet("c")
+		c.MustSet("v", MustNewValue("1"))
+		b.MustSet("c", c)
+		b.MustAppend("cs", c)
+		a.MustSet("b", b)
+		aId = a.Id()
+
+		return store.Open(tx).Save(a)
+	})
+
+	var fresh *Worksheet
+	s.MustRunTransaction(func(tx *runner.Tx) error {
+		var err error
+		fresh, err = store.Open(tx).Load(aId)
+		return err
+	})
+
+	c := fresh.MustGet("b").(*Worksheet).MustGet("c").(*Worksheet)
+	c.MustSet("v", MustNewValue("7"))
+	require.Equal(s.T(), "7", fresh.MustGet("v").String())
+	require.Equal(s.T(), "7", fresh.MustGet("total").String())
+}
+
 func (s *DbZuite) TestRefsSave_noDataInRefWorksheet() {
 	var (
 		ws     = defs.MustNewWorksheet("with_refs")
@@ -42,3 +42,16 @@ create table worksheet_slice_elements (
 
   unique(id)
 );
+
+drop table if exists worksheet_map_entries;
+create table worksheet_map_entries (
+  id             serial,
+  map_id         uuid,
+  rank           int,
+  key            varchar,
+  from_version   int,
+  to_version     int,
+  value          varchar,
+
+  unique(id)
+);
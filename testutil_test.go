@@ -28,7 +28,11 @@ var (
 
 // definitions
 var defs = MustNewDefinitions(strings.NewReader(`
-worksheet simple {
+view named {
+	name text
+}
+
+worksheet simple implements named {
 	83:name text
 	91:age  number[0]
 }
@@ -48,6 +52,35 @@ worksheet with_refs {
 
 worksheet with_refs_and_cycles {
 	404:point_to_me with_refs_and_cycles
+}
+
+worksheet with_dates {
+	5:birthday date
+	8:landed_at time
+}
+
+worksheet with_tuple {
+	3:pair tuple[text, number[2]]
+}
+
+worksheet keyed_by_name implements named {
+	1:name text
+	keyed_by { name }
+}
+
+worksheet with_map {
+	12:people map[keyed_by_name]
+}
+
+enum suffix { "Jr.", "Sr." }
+
+worksheet with_enum {
+	1:suffix suffix
+}
+
+worksheet with_named {
+	1:first named
+	2:all []named
 }`))
 
 type Zuite struct {
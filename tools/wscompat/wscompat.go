@@ -0,0 +1,56 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// wscompat reports the breaking changes between two versions of definitions,
+// exiting with a non-zero status if there are any.
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/helloeave/worksheets"
+)
+
+func main() {
+	if len(os.Args) != 3 {
+		fmt.Fprintln(os.Stderr, "Usage: wscompat old.ws new.ws")
+		os.Exit(2)
+	}
+
+	from, err := readDefinitions(os.Args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "FAIL\t%s\n", err)
+		os.Exit(2)
+	}
+	to, err := readDefinitions(os.Args[2])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "FAIL\t%s\n", err)
+		os.Exit(2)
+	}
+
+	changes := worksheets.CheckCompatibility(from, to)
+	for _, change := range changes {
+		fmt.Printf("%s\t%s\n", change.Kind, change)
+	}
+	if len(changes) != 0 {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// readDefinitions reads the definitions of a file, and of the files it
+// imports, relative to its directory.
+func readDefinitions(filename string) (*worksheets.Definitions, error) {
+	return worksheets.NewDefinitionsFromFiles(os.DirFS(filepath.Dir(filename)), []string{filepath.Base(filename)})
+}
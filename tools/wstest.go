@@ -19,6 +19,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"unicode"
 
 	"github.com/cucumber/gherkin-go"
 
@@ -122,19 +123,15 @@ onto_next_step:
 var stepFuncs = map[*regexp.Regexp]func(*runner, []string, interface{}) error{
 	re(`definitions (.*)`): func(r *runner, args []string, _ interface{}) error {
 		if r.defs != nil {
-			return fmt.Errorf("cannot provide multiple definitions files")
+			return fmt.Errorf("cannot provide definitions more than once")
 		}
 
-		filename := args[0]
+		// Files are separated by commas, or spaces.
+		filenames := strings.FieldsFunc(args[0], func(r rune) bool {
+			return r == ',' || unicode.IsSpace(r)
+		})
 
-		defsFile, err := os.Open(filepath.Join(r.currentDir, filename))
-		if err != nil {
-			return err
-		}
-		defer defsFile.Close()
-
-		reader := bufio.NewReader(defsFile)
-		defs, err := worksheets.NewDefinitions(reader)
+		defs, err := worksheets.NewDefinitionsFromFiles(os.DirFS(r.currentDir), filenames)
 		if err != nil {
 			return err
 		}
@@ -144,7 +141,7 @@ var stepFuncs = map[*regexp.Regexp]func(*runner, []string, interface{}) error{
 		return nil
 	},
 
-	re(`{name}|=|worksheet|\(|{name}|\)`): func(r *runner, args []string, extra interface{}) error {
+	re(`{name}|=|worksheet|\(|{qname}|\)`): func(r *runner, args []string, extra interface{}) error {
 		varname, name := args[0], args[1]
 
 		var contents map[string]worksheets.Value
@@ -224,6 +221,7 @@ var stepFuncs = map[*regexp.Regexp]func(*runner, []string, interface{}) error{
 func re(s string) *regexp.Regexp {
 	replacements := map[string]string{
 		`{name}`:  `([a-z](?:[a-z_]*[a-z])?)`,
+		`{qname}`: `([a-z](?:[a-z_]*[a-z])?(?:\.[a-z](?:[a-z_]*[a-z])?)?)`,
 		`{value}`: `(.*)`,
 		` `:       `\s+`,
 		`|`:       `\s*`,
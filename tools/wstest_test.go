@@ -31,6 +31,27 @@ func TestStep_definitions(t *testing.T) {
 	require.NotNil(t, runner.defs)
 }
 
+func TestStep_definitionsMultipleFiles(t *testing.T) {
+	runner := newRunner([]*gherkin.Step{
+		{Text: "definitions example.ws, with_import.ws"},
+		{Text: "foo = worksheet(with_simple)"},
+	})
+	require.NoError(t, runner.run())
+
+	require.NotNil(t, runner.defs.Definition("simple"))
+	require.NotNil(t, runner.sheets["foo"])
+}
+
+func TestStep_definitionsMoreThanOnce(t *testing.T) {
+	runner := newRunner([]*gherkin.Step{
+		{Text: "definitions example.ws"},
+		{Text: "definitions with_import.ws"},
+	})
+	if err := runner.run(); assert.Error(t, err) {
+		require.Equal(t, "cannot provide definitions more than once", err.Error())
+	}
+}
+
 func TestStep_instantiate(t *testing.T) {
 	runner := newRunner([]*gherkin.Step{
 		{Text: "definitions example.ws"},
@@ -41,6 +62,16 @@ func TestStep_instantiate(t *testing.T) {
 	require.NotNil(t, runner.sheets["foo"])
 }
 
+func TestStep_instantiateQualified(t *testing.T) {
+	runner := newRunner([]*gherkin.Step{
+		{Text: "definitions packaged.ws"},
+		{Text: "foo = worksheet(mortgage.borrower)"},
+	})
+	require.NoError(t, runner.run())
+
+	require.Equal(t, "mortgage.borrower", runner.sheets["foo"].Name())
+}
+
 func TestStep_instantiateWithTable(t *testing.T) {
 	runner := newRunner([]*gherkin.Step{
 		{Text: "definitions example.ws"},
@@ -14,10 +14,13 @@ package worksheets
 
 import (
 	"fmt"
+	"text/scanner"
 )
 
 type Definition struct {
 	name          string
+	pos           scanner.Position
+	doc           string
 	fields        []*Field
 	fieldsByName  map[string]*Field
 	fieldsByIndex map[int]*Field
@@ -25,6 +28,37 @@ type Definition struct {
 	// derived values handling
 	externals  map[int]ComputedBy
 	dependents map[int][]int
+
+	// computedFields holds all computed fields in topological order, i.e.
+	// fields come after the fields they depend on.
+	computedFields []*Field
+
+	// reactors holds user code called on every edit.
+	reactors []Reactor
+
+	// keyedBy holds the names of the fields forming the key of this
+	// worksheet, or is empty if the worksheet is not keyed. Worksheets keyed by
+	// identity are keyed by their id.
+	keyedBy []string
+
+	// keyFields holds the fields forming the key of this worksheet, and
+	// frozenFields the indexes of all fields which cannot be edited once the
+	// worksheet is in a map, i.e. key fields and all inputs to computed key
+	// fields.
+	keyFields    []*Field
+	frozenFields map[int]bool
+
+	// implements holds the views this worksheet implements.
+	implements []*View
+}
+
+// View is a set of fields which worksheets implementing the view must have,
+// allowing worksheets of different definitions to be used interchangeably.
+type View struct {
+	name         string
+	pos          scanner.Position
+	fields       []*Field
+	fieldsByName map[string]*Field
 }
 
 func (def *Definition) addField(field *Field) {
@@ -37,11 +71,13 @@ func (def *Definition) addField(field *Field) {
 }
 
 type Field struct {
-	index      int
-	name       string
-	typ        Type
-	computedBy expression
-	// also need constrainedBy *tExpression
+	index         int
+	name          string
+	pos           scanner.Position
+	doc           string
+	typ           Type
+	computedBy    expression
+	constrainedBy expression
 }
 
 func (f *Field) Type() Type {
@@ -52,6 +88,21 @@ func (f *Field) Name() string {
 	return f.name
 }
 
+// Doc returns the documentation of the field, i.e. the text of the // comments
+// immediately preceding it, or "" if it is not documented.
+func (f *Field) Doc() string {
+	return f.doc
+}
+
+// EnumValues returns the allowed values of an enum field, or nil if the field
+// is not an enum.
+func (f *Field) EnumValues() []string {
+	if enum, ok := f.typ.(*EnumType); ok {
+		return enum.Values()
+	}
+	return nil
+}
+
 type tUndefinedType struct{}
 
 type tTextType struct{}
@@ -62,18 +113,30 @@ type tNumberType struct {
 	scale int
 }
 
+type tDateType struct{}
+
+type tTimeType struct{}
+
+type tDurationType struct{}
+
 type tOp string
 
 const (
-	opPlus     tOp = "plus"
-	opMinus        = "minus"
-	opMult         = "mult"
-	opDiv          = "div"
-	opNot          = "not"
-	opEqual        = "equal"
-	opNotEqual     = "not-equal"
-	opOr           = "or"
-	opAnd          = "and"
+	opPlus           tOp = "plus"
+	opMinus              = "minus"
+	opMult               = "mult"
+	opDiv                = "div"
+	opIntDiv             = "int-div"
+	opMod                = "mod"
+	opNot                = "not"
+	opEqual              = "equal"
+	opNotEqual           = "not-equal"
+	opLess               = "less"
+	opLessOrEqual        = "less-or-equal"
+	opGreater            = "greater"
+	opGreaterOrEqual     = "greater-or-equal"
+	opOr                 = "or"
+	opAnd                = "and"
 )
 
 type tRound struct {
@@ -87,6 +150,13 @@ func (t *tRound) String() string {
 
 type tExternal struct{}
 
+// tImport is an import of the definitions of another file, whose path is
+// relative to the importing file.
+type tImport struct {
+	path string
+	pos  scanner.Position
+}
+
 type tUnop struct {
 	op   tOp
 	expr expression
@@ -106,6 +176,56 @@ type tVar struct {
 	name string
 }
 
+// tSelector selects a field, e.g. `incomes.amount`. Over slices of
+// worksheets, the field is selected in each element.
+type tSelector struct {
+	expr  expression
+	field string
+}
+
+// tLocal is a reference to a local variable, as opposed to tVar which
+// references fields.
+type tLocal struct {
+	name string
+}
+
 type tReturn struct {
 	expr expression
 }
+
+// tAssign assigns to a local variable, both when declaring (`name := expr`)
+// and when re-assigning (`name = expr`). Declarations are checked when
+// parsing.
+type tAssign struct {
+	name string
+	expr expression
+}
+
+type tIf struct {
+	cond expression
+	then *tBlock
+
+	// otherwise is either a *tBlock, another *tIf, or nil.
+	otherwise statement
+}
+
+type tBlock struct {
+	stmts []statement
+}
+
+// tCall is a call to a builtin, e.g. `len(name)`. Only calls to round have a
+// rounding mode and scale, e.g. `round(amount, down, 2)`.
+type tCall struct {
+	name  string
+	args  []expression
+	round *tRound
+}
+
+type tDuration struct {
+	amount expression
+	unit   string
+}
+
+type tDateOf struct {
+	time, tz expression
+}
@@ -0,0 +1,348 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"fmt"
+)
+
+// checker statically checks the types of the expressions of a field, i.e.
+// its computed_by or constrained_by, and collects all errors found.
+type checker struct {
+	def   *Definition
+	field *Field
+
+	// scopes holds the types of local variables in scope.
+	scopes []map[string]Type
+
+	errs DefinitionErrors
+}
+
+func (c *checker) errorf(format string, args ...interface{}) {
+	c.errs.add(c.field.pos, "%s.%s: %s", c.def.name, c.field.name, fmt.Sprintf(format, args...))
+}
+
+// checkTypes checks the types of the computed_by and constrained_by of all
+// fields of the definition.
+func (def *Definition) checkTypes() DefinitionErrors {
+	var errs DefinitionErrors
+	for _, field := range def.fields {
+		c := &checker{def: def, field: field}
+		if field.computedBy != nil {
+			c.checkReturns(field.computedBy, "computed_by", field.typ)
+		}
+		if field.constrainedBy != nil {
+			c.checkReturns(field.constrainedBy, "constrained_by", &tBoolType{})
+		}
+		errs = append(errs, c.errs...)
+	}
+	return errs
+}
+
+// checkReturns checks the statements of a computed_by or constrained_by,
+// verifying that all values returned are assignable to typ.
+func (c *checker) checkReturns(stmt interface{}, keyword string, typ Type) {
+	switch s := stmt.(type) {
+	case *tReturn:
+		if actual := c.typeOf(s.expr); isKnown(actual) && !actual.AssignableTo(typ) {
+			c.errorf("%s returns %s, not assignable to %s", keyword, actual, typ)
+		}
+	case *tAssign:
+		// The parser forbids redeclaring locals in scope, and assigning to
+		// undeclared ones, so an assignment to a local in scope is a
+		// re-assignment, which must be assignable to the declared type.
+		actual := c.typeOf(s.expr)
+		if declared, ok := c.lookup(s.name); !ok {
+			c.scopes[len(c.scopes)-1][s.name] = actual
+		} else if isKnown(declared) && isKnown(actual) && !actual.AssignableTo(declared) {
+			c.errorf("cannot assign %s to %s of type %s", actual, s.name, declared)
+		}
+	case *tBlock:
+		c.scopes = append(c.scopes, make(map[string]Type))
+		for _, inner := range s.stmts {
+			c.checkReturns(inner, keyword, typ)
+		}
+		c.scopes = c.scopes[:len(c.scopes)-1]
+	case *tIf:
+		if cond := c.typeOf(s.cond); isKnown(cond) && !isBool(cond) {
+			c.errorf("if on non-bool")
+		}
+		c.checkReturns(s.then, keyword, typ)
+		if s.otherwise != nil {
+			c.checkReturns(s.otherwise, keyword, typ)
+		}
+	}
+}
+
+func (c *checker) lookup(name string) (Type, bool) {
+	for i := len(c.scopes) - 1; 0 <= i; i-- {
+		if typ, ok := c.scopes[i][name]; ok {
+			return typ, true
+		}
+	}
+	return nil, false
+}
+
+// isKnown reports whether a type was determined statically. Undefined is
+// not considered known, since it is assignable to, and absorbed by, all
+// types.
+func isKnown(typ Type) bool {
+	if typ == nil {
+		return false
+	}
+	_, ok := typ.(*tUndefinedType)
+	return !ok
+}
+
+func isBool(typ Type) bool {
+	_, ok := typ.(*tBoolType)
+	return ok
+}
+
+func isNumber(typ Type) bool {
+	_, ok := typ.(*tNumberType)
+	return ok
+}
+
+func isText(typ Type) bool {
+	switch typ.(type) {
+	case *tTextType, *EnumType:
+		return true
+	}
+	return false
+}
+
+// typeOf statically determines the type of the values computed by an
+// expression, e.g. `amount + 5.0` computes a number[2] when amount is a
+// number[2], and records type errors. It returns nil when the type cannot be
+// determined statically, including when the expression has errors.
+func (c *checker) typeOf(expr expression) Type {
+	switch e := expr.(type) {
+	case Value:
+		return e.Type()
+	case *tVar:
+		if field, ok := c.def.fieldsByName[e.name]; ok {
+			return field.typ
+		}
+	case *tLocal:
+		typ, _ := c.lookup(e.name)
+		return typ
+	case *tSelector:
+		return c.selectorType(c.typeOf(e.expr), e.field)
+	case *tUnop:
+		if typ := c.typeOf(e.expr); isKnown(typ) && !isBool(typ) {
+			c.errorf("! on non-bool")
+			return nil
+		}
+		return &tBoolType{}
+	case *tBinop:
+		return c.binopType(e)
+	case *tCall:
+		return c.callType(e)
+	case *tDuration:
+		amount := c.typeOf(e.amount)
+		if num, ok := amount.(*tNumberType); ok && num.scale != 0 {
+			c.errorf("duration must be a whole number, found %s", num)
+			return nil
+		} else if isKnown(amount) && !ok {
+			c.errorf("duration must be a number")
+			return nil
+		}
+		return &tDurationType{}
+	case *tDateOf:
+		if typ := c.typeOf(e.time); isKnown(typ) {
+			if _, ok := typ.(*tTimeType); !ok {
+				c.errorf("date conversion on non-time")
+				return nil
+			}
+		}
+		if typ := c.typeOf(e.tz); isKnown(typ) && !isText(typ) {
+			c.errorf("date conversion with non-text time zone")
+			return nil
+		}
+		return &tDateType{}
+	}
+	return nil
+}
+
+// selectorType determines the type of selecting field on a value of type typ,
+// be it a worksheet, a view, or a slice of either.
+func (c *checker) selectorType(typ Type, field string) Type {
+	if !isKnown(typ) {
+		return nil
+	}
+
+	elementType := typ
+	slice, isSlice := typ.(*SliceType)
+	if isSlice {
+		elementType = slice.elementType
+	}
+	withFields, ok := elementType.(interface {
+		FieldByName(name string) *Field
+	})
+	if !ok {
+		c.errorf("cannot select %s on %s", field, typ)
+		return nil
+	}
+	f := withFields.FieldByName(field)
+	if f == nil {
+		c.errorf("unknown field %s.%s", elementType, field)
+		return nil
+	}
+	if isSlice {
+		return &SliceType{f.typ}
+	}
+	return f.typ
+}
+
+func (c *checker) binopType(e *tBinop) Type {
+	left, right := c.typeOf(e.left), c.typeOf(e.right)
+
+	switch e.op {
+	case opAnd, opOr:
+		if (isKnown(left) && !isBool(left)) || (isKnown(right) && !isBool(right)) {
+			c.errorf("op on non-bool")
+			return nil
+		}
+		return &tBoolType{}
+	case opEqual, opNotEqual:
+		return &tBoolType{}
+	case opLess, opLessOrEqual, opGreater, opGreaterOrEqual:
+		if isKnown(left) && isKnown(right) && !orderable(left, right) {
+			c.errorf("cannot compare %s and %s", left, right)
+			return nil
+		}
+		return &tBoolType{}
+	}
+
+	// Rounding always yields a number at the scale of the rounding.
+	var result Type
+	if e.round != nil {
+		result = &tNumberType{e.round.scale}
+	}
+	if !isKnown(left) || !isKnown(right) {
+		return result
+	}
+
+	// date and time arithmetic
+	if _, ok := right.(*tDurationType); ok {
+		if e.op != opPlus && e.op != opMinus {
+			c.errorf("op on duration")
+			return nil
+		}
+		switch left.(type) {
+		case *tDateType, *tTimeType:
+			return left
+		default:
+			c.errorf("duration added to non-date and non-time")
+			return nil
+		}
+	}
+
+	// text concatenation
+	if isText(left) {
+		if !isText(right) || e.op != opPlus {
+			c.errorf("op on text")
+			return nil
+		}
+		return &tTextType{}
+	}
+
+	// numerical operations
+	l, lok := left.(*tNumberType)
+	r, rok := right.(*tNumberType)
+	if !lok || !rok {
+		c.errorf("op on non-number")
+		return nil
+	}
+	if e.op == opDiv {
+		if e.round == nil {
+			c.errorf("division without rounding mode")
+		}
+		return result
+	}
+	if result != nil {
+		return result
+	}
+	return &tNumberType{resultScale(e.op, l.scale, r.scale)}
+}
+
+// orderable reports whether values of types left and right can be ordered.
+func orderable(left, right Type) bool {
+	switch left.(type) {
+	case *tNumberType:
+		return isNumber(right)
+	case *tTextType, *EnumType:
+		return isText(right)
+	case *tDateType:
+		_, ok := right.(*tDateType)
+		return ok
+	case *tTimeType:
+		_, ok := right.(*tTimeType)
+		return ok
+	}
+	return false
+}
+
+func (c *checker) callType(e *tCall) Type {
+	args := make([]Type, len(e.args))
+	for i, arg := range e.args {
+		args[i] = c.typeOf(arg)
+	}
+
+	if e.round != nil {
+		if isKnown(args[0]) && !isNumber(args[0]) {
+			c.errorf("round: argument 1 must be number, found %s", args[0])
+			return nil
+		}
+		return &tNumberType{e.round.scale}
+	}
+
+	b := builtins[e.name]
+	for i, arg := range args {
+		if isKnown(arg) && !b.acceptsArgType(i, arg) {
+			c.errorf("%s: argument %d must be %s, found %s", e.name, i+1, b.argKind(i), arg)
+			return nil
+		}
+	}
+	typ, err := b.typeOf(args)
+	if err != nil {
+		c.errorf("%s", err)
+		return nil
+	}
+	return typ
+}
+
+// joinTypes determines the type of values of any of the given types, e.g.
+// joining number[2] and number[3] yields number[3]. Undefined types are
+// ignored.
+func joinTypes(types []Type) Type {
+	var result Type
+	for _, typ := range types {
+		if typ == nil {
+			return nil
+		} else if !isKnown(typ) {
+			continue
+		}
+		switch {
+		case result == nil:
+			result = typ
+		case typ.AssignableTo(result):
+		case result.AssignableTo(typ):
+			result = typ
+		default:
+			return nil
+		}
+	}
+	return result
+}
@@ -0,0 +1,195 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"strings"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var typecheckDefs = MustNewDefinitions(strings.NewReader(`
+worksheet payment {
+	1:amount number[2]
+	2:memo text
+}
+
+worksheet loan {
+	1:amount number[2]
+	2:rate number[4]
+	3:name text
+	4:payment payment
+	5:payments []payment
+	6:start date
+}`))
+
+func (s *Zuite) TestTypecheck_typeOf() {
+	def := typecheckDefs.defs["loan"]
+	cases := map[string]Type{
+		`5.03 + 6.000`:                  &tNumberType{3},
+		`amount + 1`:                    &tNumberType{2},
+		`amount - rate`:                 &tNumberType{4},
+		`amount * rate`:                 &tNumberType{6},
+		`amount % 3.5`:                  &tNumberType{2},
+		`amount div 3`:                  &tNumberType{0},
+		`amount * rate round down 2`:    &tNumberType{2},
+		`round(amount * rate, half, 1)`: &tNumberType{1},
+		`amount == 5`:                   &tBoolType{},
+		`!(amount < 5)`:                 &tBoolType{},
+		`name + "!"`:                    &tTextType{},
+		`payment.amount`:                &tNumberType{2},
+		`payment.memo`:                  &tTextType{},
+		`payments.amount`:               &SliceType{&tNumberType{2}},
+		`start + 2 days`:                &tDateType{},
+		`amount / 3 round down 2 / 4 round down 2`: &tNumberType{2},
+		`len(name)`: &tNumberType{0},
+
+		// unknown statically
+		`payment.unknown`: nil,
+		`unknown + 5`:     nil,
+	}
+	for input, expected := range cases {
+		p := newParser(strings.NewReader(input))
+		expr, err := p.parseExpression(true)
+		require.NoError(s.T(), err, input)
+		c := &checker{def: def, field: def.fieldsByName["amount"]}
+		assert.Equal(s.T(), expected, c.typeOf(expr), input)
+	}
+}
+
+func (s *Zuite) TestTypecheck_returnTypes() {
+	// no rounding is needed when adding numbers of lower scales
+	_, err := NewDefinitions(strings.NewReader(`worksheet loan {
+		1:amount number[2]
+		2:fee number[1]
+		3:total number[2] computed_by {
+			return amount + fee
+		}
+	}`))
+	require.NoError(s.T(), err)
+
+	cases := map[string]string{
+		`3:total number[2] computed_by { return amount + fee }`:                        `4:4: loan.total: computed_by returns number[3], not assignable to number[2]`,
+		`3:total number[2] computed_by { return amount * fee }`:                        `4:4: loan.total: computed_by returns number[5], not assignable to number[2]`,
+		`3:total text computed_by { return amount }`:                                   `4:4: loan.total: computed_by returns number[2], not assignable to text`,
+		`3:total bool computed_by { return amount + 1 }`:                               `4:4: loan.total: computed_by returns number[2], not assignable to bool`,
+		`3:total number[2] computed_by { if amount > 1 { return 1 } return fee }`:      `4:4: loan.total: computed_by returns number[3], not assignable to number[2]`,
+		`3:total number[2] computed_by { if amount > 1 { return "a" } return amount }`: `4:4: loan.total: computed_by returns text, not assignable to number[2]`,
+	}
+	for field, expected := range cases {
+		_, err := NewDefinitions(strings.NewReader(`worksheet loan {
+			1:amount number[2]
+			2:fee number[3]
+			` + field + `
+		}`))
+		assert.EqualError(s.T(), err, expected, field)
+	}
+}
+
+func (s *Zuite) TestTypecheck_errors() {
+	cases := map[string]string{
+		`3:total bool computed_by { return !amount }`:                       `4:4: loan.total: ! on non-bool`,
+		`3:total number[2] computed_by { return amount + memo }`:            `4:4: loan.total: op on non-number`,
+		`3:total number[2] computed_by { return amount / 3 }`:               `4:4: loan.total: division without rounding mode`,
+		`3:total bool computed_by { return amount < memo }`:                 `4:4: loan.total: cannot compare number[2] and text`,
+		`3:total number[2] computed_by { if amount { return 1 } return 2 }`: `4:4: loan.total: if on non-bool`,
+		`3:total number[2] computed_by { return amount.value }`:             `4:4: loan.total: cannot select value on number[2]`,
+		`3:total number[2] constrained_by { return amount }`:                `4:4: loan.total: constrained_by returns number[2], not assignable to bool`,
+	}
+	for field, expected := range cases {
+		_, err := NewDefinitions(strings.NewReader(`worksheet loan {
+			1:amount number[2]
+			2:memo text
+			` + field + `
+		}`))
+		assert.EqualError(s.T(), err, expected, field)
+	}
+}
+
+func (s *Zuite) TestTypecheck_reassignments() {
+	cases := map[string]string{
+		`3:total number[0] computed_by {
+			x := count
+			if count > 1 {
+				x = 2.55
+			}
+			return x
+		}`: `4:4: loan.total: cannot assign number[2] to x of type number[0]`,
+
+		`3:total number[0] computed_by {
+			x := count
+			if count > 1 {
+				x = memo
+			}
+			return x
+		}`: `4:4: loan.total: cannot assign text to x of type number[0]`,
+
+		`3:total number[0] computed_by {
+			x := count
+			if count > 1 {
+				y := memo
+				x = y
+			}
+			return x
+		}`: `4:4: loan.total: cannot assign text to x of type number[0]`,
+	}
+	for field, expected := range cases {
+		_, err := NewDefinitions(strings.NewReader(`worksheet loan {
+			1:count number[0]
+			2:memo text
+			` + field + `
+		}`))
+		assert.EqualError(s.T(), err, expected, field)
+	}
+
+	defs := MustNewDefinitions(strings.NewReader(`worksheet loan {
+		1:count number[0]
+		2:total number[2] computed_by {
+			x := 1.50
+			if count > 1 {
+				x = count
+			}
+			if count > 2 {
+				y := memo
+			}
+			if count > 3 {
+				y := count
+				x = y
+			}
+			return x
+		}
+		3:memo text
+	}`))
+	ws := defs.MustNewWorksheet("loan")
+	ws.MustSet("count", MustNewValue("5"))
+	require.Equal(s.T(), "5", ws.MustGet("total").String())
+}
+
+func (s *Zuite) TestTypecheck_reportsAllErrors() {
+	_, err := NewDefinitions(strings.NewReader(`
+	worksheet payment {
+		1:amount number[2]
+		2:late bool computed_by { return amount }
+	}
+	worksheet loan {
+		1:amount number[2]
+		2:fee number[2] computed_by { return amount / 10 }
+		3:memo text computed_by { return amount }
+	}`))
+	require.EqualError(s.T(), err, strings.Join([]string{
+		`4:3: payment.late: computed_by returns number[2], not assignable to bool`,
+		`8:3: loan.fee: division without rounding mode`,
+		`9:3: loan.memo: computed_by returns number[2], not assignable to text`,
+	}, "\n"))
+}
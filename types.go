@@ -14,6 +14,7 @@ package worksheets
 
 import (
 	"fmt"
+	"strings"
 )
 
 // Type represents the type of a value.
@@ -31,8 +32,15 @@ var _ []Type = []Type{
 	&tTextType{},
 	&tBoolType{},
 	&tNumberType{},
+	&tDateType{},
+	&tTimeType{},
+	&tDurationType{},
 	&SliceType{},
+	&MapType{},
+	&TupleType{},
 	&Definition{},
+	&View{},
+	&EnumType{},
 }
 
 type SliceType struct {
@@ -43,6 +51,59 @@ func (s *SliceType) ElementType() Type {
 	return s.elementType
 }
 
+type MapType struct {
+	elementType Type
+}
+
+func (m *MapType) ElementType() Type {
+	return m.elementType
+}
+
+// TupleType represents N-tuples of base types, e.g. `tuple[text, number[2]]`.
+type TupleType struct {
+	elementTypes []Type
+}
+
+func (t *TupleType) ElementTypes() []Type {
+	return t.elementTypes
+}
+
+// EnumType represents texts restricted to a list of allowed values, e.g.
+// `enum suffix { "Jr.", "Sr." }`.
+type EnumType struct {
+	name   string
+	values []string
+}
+
+func (e *EnumType) Name() string {
+	return e.name
+}
+
+// Values returns the allowed values of the enum, in declaration order.
+func (e *EnumType) Values() []string {
+	return append([]string(nil), e.values...)
+}
+
+func (e *EnumType) has(value string) bool {
+	for _, allowed := range e.values {
+		if allowed == value {
+			return true
+		}
+	}
+	return false
+}
+
+// isBaseType reports whether typ is a base type, i.e. text, bool, number,
+// date, or time.
+func isBaseType(typ Type) bool {
+	switch typ.(type) {
+	case *tTextType, *tBoolType, *tNumberType, *tDateType, *tTimeType:
+		return true
+	default:
+		return false
+	}
+}
+
 func (typ *tUndefinedType) AssignableTo(_ Type) bool {
 	return true
 }
@@ -52,8 +113,14 @@ func (typ *tUndefinedType) String() string {
 }
 
 func (typ *tTextType) AssignableTo(u Type) bool {
-	_, ok := u.(*tTextType)
-	return ok
+	// Texts are assignable to enums at the type level, and membership
+	// is checked upon assignment.
+	switch u.(type) {
+	case *tTextType, *EnumType:
+		return true
+	default:
+		return false
+	}
 }
 
 func (typ *tTextType) String() string {
@@ -78,6 +145,46 @@ func (typ *tNumberType) String() string {
 	return fmt.Sprintf("number[%d]", typ.scale)
 }
 
+func (typ *tDateType) AssignableTo(u Type) bool {
+	_, ok := u.(*tDateType)
+	return ok
+}
+
+func (typ *tDateType) String() string {
+	return "date"
+}
+
+func (typ *tTimeType) AssignableTo(u Type) bool {
+	_, ok := u.(*tTimeType)
+	return ok
+}
+
+func (typ *tTimeType) String() string {
+	return "time"
+}
+
+func (typ *tDurationType) AssignableTo(u Type) bool {
+	_, ok := u.(*tDurationType)
+	return ok
+}
+
+func (typ *tDurationType) String() string {
+	return "duration"
+}
+
+func (typ *EnumType) AssignableTo(u Type) bool {
+	switch u.(type) {
+	case *tTextType:
+		return true
+	default:
+		return typ == u
+	}
+}
+
+func (typ *EnumType) String() string {
+	return typ.name
+}
+
 func (typ *SliceType) AssignableTo(u Type) bool {
 	other, ok := u.(*SliceType)
 	return ok && typ.elementType.AssignableTo(other.elementType)
@@ -87,10 +194,56 @@ func (typ *SliceType) String() string {
 	return fmt.Sprintf("[]%s", typ.elementType)
 }
 
+func (typ *MapType) AssignableTo(u Type) bool {
+	other, ok := u.(*MapType)
+	return ok && typ.elementType.AssignableTo(other.elementType)
+}
+
+func (typ *MapType) String() string {
+	return fmt.Sprintf("map[%s]", typ.elementType)
+}
+
+func (typ *TupleType) AssignableTo(u Type) bool {
+	other, ok := u.(*TupleType)
+	if !ok || len(typ.elementTypes) != len(other.elementTypes) {
+		return false
+	}
+	for i := range typ.elementTypes {
+		if !typ.elementTypes[i].AssignableTo(other.elementTypes[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (typ *TupleType) String() string {
+	parts := make([]string, len(typ.elementTypes))
+	for i, elementType := range typ.elementTypes {
+		parts[i] = elementType.String()
+	}
+	return fmt.Sprintf("tuple[%s]", strings.Join(parts, ", "))
+}
+
 func (def *Definition) AssignableTo(u Type) bool {
 	// Since we do type resolution, pointer equality suffices to
 	// guarantee assignability.
-	return def == u
+	if def == u {
+		return true
+	}
+	if view, ok := u.(*View); ok {
+		return def.Implements(view)
+	}
+	return false
+}
+
+// Implements reports whether the definition implements the view.
+func (def *Definition) Implements(view *View) bool {
+	for _, implemented := range def.implements {
+		if implemented == view {
+			return true
+		}
+	}
+	return false
 }
 
 func (def *Definition) String() string {
@@ -104,3 +257,26 @@ func (def *Definition) FieldByName(name string) *Field {
 func (def *Definition) Fields() []*Field {
 	return def.fields
 }
+
+// Doc returns the documentation of the worksheet, i.e. the text of the //
+// comments immediately preceding its definition, or "" if it is not
+// documented.
+func (def *Definition) Doc() string {
+	return def.doc
+}
+
+func (view *View) AssignableTo(u Type) bool {
+	return view == u
+}
+
+func (view *View) String() string {
+	return view.name
+}
+
+func (view *View) FieldByName(name string) *Field {
+	return view.fieldsByName[name]
+}
+
+func (view *View) Fields() []*Field {
+	return view.fields
+}
@@ -14,6 +14,7 @@ package worksheets
 
 import (
 	"strings"
+	"text/scanner"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -34,6 +35,20 @@ func (s *Zuite) TestTypeAssignableTo() {
 
 		{&tNumberType{0}, &tNumberType{0}},
 		{&tNumberType{1}, &tNumberType{1}},
+
+		{&tUndefinedType{}, &tDateType{}},
+		{&tUndefinedType{}, &tTimeType{}},
+		{&tDateType{}, &tDateType{}},
+		{&tTimeType{}, &tTimeType{}},
+
+		{
+			&TupleType{[]Type{&tTextType{}, &tNumberType{1}}},
+			&TupleType{[]Type{&tTextType{}, &tNumberType{2}}},
+		},
+		{
+			&TupleType{[]Type{&tUndefinedType{}, &tDateType{}}},
+			&TupleType{[]Type{&tBoolType{}, &tDateType{}}},
+		},
 	}
 	for _, ex := range cases {
 		require.True(s.T(), ex.left.AssignableTo(ex.right), "%s should be assignable to %s", ex.left, ex.right)
@@ -57,6 +72,22 @@ func (s *Zuite) TestTypeNotAssignableTo() {
 
 		{&tTextType{}, &tNumberType{1}},
 		{&tNumberType{2}, &tNumberType{1}},
+
+		{&tDateType{}, &tTimeType{}},
+		{&tTimeType{}, &tDateType{}},
+		{&tTextType{}, &tDateType{}},
+		{&tDurationType{}, &tTimeType{}},
+
+		{
+			&TupleType{[]Type{&tTextType{}, &tNumberType{2}}},
+			&TupleType{[]Type{&tTextType{}, &tNumberType{1}}},
+		},
+		{
+			&TupleType{[]Type{&tTextType{}}},
+			&TupleType{[]Type{&tTextType{}, &tTextType{}}},
+		},
+		{&TupleType{[]Type{&tTextType{}}}, &tTextType{}},
+		{&tTextType{}, &TupleType{[]Type{&tTextType{}}}},
 	}
 	for _, ex := range cases {
 		assert.False(s.T(), ex.left.AssignableTo(ex.right), "%s should not be assignable to %s", ex.left, ex.right)
@@ -65,12 +96,15 @@ func (s *Zuite) TestTypeNotAssignableTo() {
 
 func (s *Zuite) TestTypeString() {
 	cases := map[Type]string{
-		&tUndefinedType{}:           "undefined",
-		&tTextType{}:                "text",
-		&tBoolType{}:                "bool",
-		&tNumberType{1}:             "number[1]",
-		&SliceType{&tBoolType{}}:    "[]bool",
-		&Definition{name: "simple"}: "simple",
+		&tUndefinedType{}:        "undefined",
+		&tTextType{}:             "text",
+		&tBoolType{}:             "bool",
+		&tNumberType{1}:          "number[1]",
+		&tDateType{}:             "date",
+		&tTimeType{}:             "time",
+		&SliceType{&tBoolType{}}: "[]bool",
+		&TupleType{[]Type{&tTextType{}, &tNumberType{2}}}: "tuple[text, number[2]]",
+		&Definition{name: "simple"}:                       "simple",
 	}
 	for typ, expected := range cases {
 		assert.Equal(s.T(), expected, typ.String(), expected)
@@ -90,6 +124,7 @@ func (s *Zuite) TestWorksheetDefinition_Fields() {
 		{
 			index: 1,
 			name:  "name",
+			pos:   scanner.Position{Offset: 18, Line: 1, Column: 19},
 			typ:   &tTextType{},
 		},
 		{
@@ -15,9 +15,11 @@ package worksheets
 import (
 	"bytes"
 	"fmt"
+	"math/big"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/satori/go.uuid"
 )
@@ -27,12 +29,43 @@ var (
 )
 
 // RoundingMode describes the rounding mode to be used in an operation.
+//
+// Rounding to a scale of 0, the modes behave as follows
+//
+//	mode        2.5   1.5   1.2  -1.2  -1.5  -2.5
+//	up            3     2     2    -2    -2    -3
+//	down          2     1     1    -1    -1    -2
+//	ceiling       3     2     2    -1    -1    -2
+//	floor         2     1     1    -2    -2    -3
+//	half          3     2     1    -1    -2    -3
+//	half_down     2     1     1    -1    -1    -2
+//	even          2     2     1    -1    -2    -2
 type RoundingMode string
 
 const (
-	ModeUp   RoundingMode = "up"
-	ModeDown              = "down"
-	ModeHalf              = "half"
+	// ModeUp rounds away from zero.
+	ModeUp RoundingMode = "up"
+
+	// ModeDown rounds towards zero.
+	ModeDown RoundingMode = "down"
+
+	// ModeCeiling rounds towards positive infinity.
+	ModeCeiling RoundingMode = "ceiling"
+
+	// ModeFloor rounds towards negative infinity.
+	ModeFloor RoundingMode = "floor"
+
+	// ModeHalf rounds to the nearest neighbor, and away from zero when both
+	// neighbors are equidistant.
+	ModeHalf RoundingMode = "half"
+
+	// ModeHalfDown rounds to the nearest neighbor, and towards zero when both
+	// neighbors are equidistant.
+	ModeHalfDown RoundingMode = "half_down"
+
+	// ModeEven rounds to the nearest neighbor, and to the even neighbor when
+	// both neighbors are equidistant. This is also known as banker's rounding.
+	ModeEven RoundingMode = "even"
 )
 
 // Value represents a runtime value.
@@ -53,9 +86,14 @@ var _ []Value = []Value{
 	&Number{},
 	&Text{},
 	&Bool{},
+	&Date{},
+	&Time{},
+	&Tuple{},
 
 	// Internals.
+	&duration{},
 	&slice{},
+	&mapValue{},
 	&Worksheet{},
 }
 
@@ -82,6 +120,57 @@ func (value *Bool) Value() bool {
 	return value.value
 }
 
+// Date represents a specific date, e.g. 7/20/1969.
+type Date struct {
+	// value is the date at midnight UTC.
+	value time.Time
+}
+
+// Time represents an instant in time.
+type Time struct {
+	// value is the instant, in UTC.
+	value time.Time
+}
+
+// Tuple represents an N-tuple of base values, e.g. `tuple("Alice", 42)`.
+type Tuple struct {
+	values []Value
+}
+
+const (
+	// dateLayout is the layout used to represent dates.
+	dateLayout = "2006-01-02"
+
+	// timeLayout is the layout used to represent times.
+	timeLayout = time.RFC3339Nano
+)
+
+// duration represents an amount of time, e.g. 18 years, and is used in date
+// and time arithmetic. Durations cannot be stored in worksheets.
+type duration struct {
+	amount int64
+	unit   string
+}
+
+// durationUnits maps the units which can be used in durations, to their
+// normalized (singular) form.
+var durationUnits = map[string]string{
+	"year":    "year",
+	"years":   "year",
+	"month":   "month",
+	"months":  "month",
+	"week":    "week",
+	"weeks":   "week",
+	"day":     "day",
+	"days":    "day",
+	"hour":    "hour",
+	"hours":   "hour",
+	"minute":  "minute",
+	"minutes": "minute",
+	"second":  "second",
+	"seconds": "second",
+}
+
 func NewValue(value string) (Value, error) {
 	reader := strings.NewReader(value)
 	p := newParser(reader)
@@ -167,95 +256,193 @@ func (value *Number) String() string {
 	return buffer.String()
 }
 
-func (value *Number) scaleUp(scale int) int64 {
+// scaleUp returns the value of the number at a scale at least as large as its
+// own. Arithmetic is carried out on big integers, such that intermediate
+// results cannot overflow.
+func (value *Number) scaleUp(scale int) *big.Int {
 	if scale < value.typ.scale {
 		panic("must round to lower scale")
 	}
 
-	v := value.value
-	for s := value.typ.scale; s < scale; s++ {
-		v *= 10
+	v := big.NewInt(value.value)
+	if value.typ.scale < scale {
+		v.Mul(v, pow10(scale-value.typ.scale))
 	}
 
 	return v
 }
 
-func (left *Number) Plus(right *Number) *Number {
-	scale := left.typ.scale + right.typ.scale
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// newNumberFromBig creates a number of the given scale, erroring when the value
+// cannot be represented.
+func newNumberFromBig(v *big.Int, scale int) (*Number, error) {
+	if !v.IsInt64() {
+		return nil, fmt.Errorf("number[%d] overflow", scale)
+	}
+	return &Number{v.Int64(), &tNumberType{scale}}, nil
+}
+
+// resultScale returns the scale of the result of an arithmetic operation on
+// numbers of scales n and m, before any rounding. Division is excluded, since
+// its scale is always given by its rounding.
+func resultScale(op tOp, n, m int) int {
+	switch op {
+	case opPlus, opMinus, opMod:
+		if n < m {
+			return m
+		}
+		return n
+	case opMult:
+		return n + m
+	case opIntDiv:
+		return 0
+	default:
+		panic(fmt.Sprintf("no result scale for %s", op))
+	}
+}
+
+func (left *Number) Plus(right *Number) (*Number, error) {
+	scale := resultScale(opPlus, left.typ.scale, right.typ.scale)
 	lv, rv := left.scaleUp(scale), right.scaleUp(scale)
 
-	return &Number{lv + rv, &tNumberType{scale}}
+	return newNumberFromBig(lv.Add(lv, rv), scale)
 }
 
-func (left *Number) Minus(right *Number) *Number {
-	scale := left.typ.scale + right.typ.scale
+func (left *Number) Minus(right *Number) (*Number, error) {
+	scale := resultScale(opMinus, left.typ.scale, right.typ.scale)
 	lv, rv := left.scaleUp(scale), right.scaleUp(scale)
 
-	return &Number{lv - rv, &tNumberType{scale}}
+	return newNumberFromBig(lv.Sub(lv, rv), scale)
 }
 
-func (left *Number) Mult(right *Number) *Number {
-	scale := left.typ.scale + right.typ.scale
-	return &Number{left.value * right.value, &tNumberType{scale}}
+// compare returns -1, 0 or 1 when left is respectively less than, equal to,
+// or greater than right, irrespective of their scales.
+func (left *Number) compare(right *Number) int {
+	scale := left.typ.scale
+	if scale < right.typ.scale {
+		scale = right.typ.scale
+	}
+	lv, rv := left.scaleUp(scale), right.scaleUp(scale)
+
+	return lv.Cmp(rv)
 }
 
-func (value *Number) Round(mode RoundingMode, scale int) *Number {
+func (left *Number) Mult(right *Number) (*Number, error) {
+	scale := resultScale(opMult, left.typ.scale, right.typ.scale)
+	lv, rv := big.NewInt(left.value), big.NewInt(right.value)
+
+	return newNumberFromBig(lv.Mul(lv, rv), scale)
+}
+
+func (value *Number) Round(mode RoundingMode, scale int) (*Number, error) {
 	if value.typ.scale == scale {
-		return value
-	} else if value.typ.scale < scale {
-		v := value.scaleUp(scale)
-		return &Number{v, &tNumberType{scale}}
+		return value, nil
 	}
+	return round(big.NewInt(value.value), value.typ.scale, mode, scale)
+}
 
-	factor := int64(1)
-	for i := value.typ.scale; i != scale; i-- {
-		factor = factor * 10
+// round rounds the value v, of scale fromScale, to the given scale.
+func round(v *big.Int, fromScale int, mode RoundingMode, scale int) (*Number, error) {
+	if fromScale <= scale {
+		v.Mul(v, pow10(scale-fromScale))
+		return newNumberFromBig(v, scale)
 	}
+	return roundQuo(v, pow10(fromScale-scale), mode, scale)
+}
 
-	remainder := value.value % factor
-
-	v := value.value
-	for i := value.typ.scale; i != scale; i-- {
-		v = v / 10
+// roundQuo rounds the exact quotient num / den to a number of the given scale,
+// where num and den are already scaled such that the integer part of the
+// quotient is the value of the result.
+func roundQuo(num, den *big.Int, mode RoundingMode, scale int) (*Number, error) {
+	q, r := new(big.Int).QuoRem(num, den, new(big.Int))
+	if r.Sign() == 0 {
+		return newNumberFromBig(q, scale)
 	}
 
+	// Having truncated, we determine whether to instead round away from zero,
+	// comparing twice the remainder to the denominator in the half modes.
+	var (
+		sign         = r.Sign() * den.Sign()
+		twice        = new(big.Int).Abs(r)
+		half         = twice.Mul(twice, big.NewInt(2)).Cmp(new(big.Int).Abs(den))
+		awayFromZero bool
+	)
 	switch mode {
+	case ModeUp:
+		awayFromZero = true
 	case ModeDown:
-		return &Number{v, &tNumberType{scale}}
+		awayFromZero = false
+	case ModeCeiling:
+		awayFromZero = sign > 0
+	case ModeFloor:
+		awayFromZero = sign < 0
+	case ModeHalf:
+		awayFromZero = half >= 0
+	case ModeHalfDown:
+		awayFromZero = half > 0
+	case ModeEven:
+		awayFromZero = half > 0 || (half == 0 && q.Bit(0) == 1)
+	default:
+		return nil, fmt.Errorf("unknown rounding mode %s", mode)
+	}
+	if awayFromZero {
+		q.Add(q, big.NewInt(int64(sign)))
+	}
 
-	case ModeUp:
-		var up int64
-		if remainder != 0 {
-			up = 1
-		}
-		return &Number{v + up, &tNumberType{scale}}
+	return newNumberFromBig(q, scale)
+}
 
-	case ModeHalf:
-		var up int64
-		threshold := 5 * factor / 10
-		if remainder > 0 && threshold <= remainder {
-			up = 1
-		} else if remainder < 0 && remainder <= -threshold {
-			up = -1
-		}
-		return &Number{v + up, &tNumberType{scale}}
+func (left *Number) Div(right *Number, mode RoundingMode, scale int) (*Number, error) {
+	if right.value == 0 {
+		return nil, fmt.Errorf("division by zero")
 	}
 
-	return value
+	// The quotient at the given scale is
+	//
+	//	left.value * 10^(scale + right.scale - left.scale) / right.value
+	//
+	// which we compute exactly before rounding.
+	num, den := big.NewInt(left.value), big.NewInt(right.value)
+	if exp := scale + right.typ.scale - left.typ.scale; exp >= 0 {
+		num.Mul(num, pow10(exp))
+	} else {
+		den.Mul(den, pow10(-exp))
+	}
+	return roundQuo(num, den, mode, scale)
 }
 
-func (left *Number) Div(right *Number, mode RoundingMode, scale int) *Number {
-	// tempScale = max(left.typ.scale, scale + right.typ.scale) + 1
-	tempScale := scale + right.typ.scale
-	if left.typ.scale > tempScale {
-		tempScale = left.typ.scale
+// IntDiv computes the integer quotient of left by right, truncated towards
+// zero, as a number[0].
+func (left *Number) IntDiv(right *Number) (*Number, error) {
+	if right.value == 0 {
+		return nil, fmt.Errorf("division by zero")
 	}
-	tempScale = tempScale + 1
 
-	// scale up left, integer division, and round correctly to finalize
-	lv := left.scaleUp(tempScale)
-	temp := &Number{lv / right.value, &tNumberType{tempScale - right.typ.scale}}
-	return temp.Round(mode, scale)
+	scale := left.typ.scale
+	if scale < right.typ.scale {
+		scale = right.typ.scale
+	}
+	lv, rv := left.scaleUp(scale), right.scaleUp(scale)
+
+	return newNumberFromBig(lv.Quo(lv, rv), resultScale(opIntDiv, left.typ.scale, right.typ.scale))
+}
+
+// Mod computes the remainder of the integer division of left by right, which
+// has the sign of left. The rule for decimal treatment is
+//
+//	number[n] % number[m] yields number[max(n, m)]
+func (left *Number) Mod(right *Number) (*Number, error) {
+	if right.value == 0 {
+		return nil, fmt.Errorf("modulo by zero")
+	}
+
+	scale := resultScale(opMod, left.typ.scale, right.typ.scale)
+	lv, rv := left.scaleUp(scale), right.scaleUp(scale)
+
+	return newNumberFromBig(lv.Rem(lv, rv), scale)
 }
 
 func NewText(value string) Value {
@@ -302,6 +489,218 @@ func (value *Bool) String() string {
 	return strconv.FormatBool(value.value)
 }
 
+func NewDate(year int, month time.Month, day int) Value {
+	return &Date{time.Date(year, month, day, 0, 0, 0, 0, time.UTC)}
+}
+
+func newDateFromString(value string) (Value, error) {
+	t, err := time.Parse(dateLayout, value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid date %s", value)
+	}
+	return &Date{t}, nil
+}
+
+func (value *Date) Type() Type {
+	return &tDateType{}
+}
+
+// Value returns the date, at midnight UTC.
+func (value *Date) Value() time.Time {
+	return value.value
+}
+
+func (value *Date) Equal(that Value) bool {
+	typed, ok := that.(*Date)
+	if !ok {
+		return false
+	}
+	return value.value.Equal(typed.value)
+}
+
+func (value *Date) Before(that *Date) bool {
+	return value.value.Before(that.value)
+}
+
+func (value *Date) After(that *Date) bool {
+	return value.value.After(that.value)
+}
+
+func (value *Date) String() string {
+	return fmt.Sprintf("date(%s)", strconv.Quote(value.value.Format(dateLayout)))
+}
+
+func (value *Date) add(d *duration) (*Date, error) {
+	switch d.unit {
+	case "year":
+		return &Date{addMonths(value.value, int(12*d.amount))}, nil
+	case "month":
+		return &Date{addMonths(value.value, int(d.amount))}, nil
+	case "week":
+		return &Date{value.value.AddDate(0, 0, int(7*d.amount))}, nil
+	case "day":
+		return &Date{value.value.AddDate(0, 0, int(d.amount))}, nil
+	default:
+		return nil, fmt.Errorf("cannot add %ss to date", d.unit)
+	}
+}
+
+func NewTime(t time.Time) Value {
+	return &Time{t.UTC()}
+}
+
+func newTimeFromString(value string) (Value, error) {
+	t, err := time.Parse(timeLayout, value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time %s", value)
+	}
+	return &Time{t.UTC()}, nil
+}
+
+func (value *Time) Type() Type {
+	return &tTimeType{}
+}
+
+// Value returns the instant, in UTC.
+func (value *Time) Value() time.Time {
+	return value.value
+}
+
+func (value *Time) Equal(that Value) bool {
+	typed, ok := that.(*Time)
+	if !ok {
+		return false
+	}
+	return value.value.Equal(typed.value)
+}
+
+func (value *Time) Before(that *Time) bool {
+	return value.value.Before(that.value)
+}
+
+func (value *Time) After(that *Time) bool {
+	return value.value.After(that.value)
+}
+
+func (value *Time) String() string {
+	return fmt.Sprintf("time(%s)", strconv.Quote(value.value.Format(timeLayout)))
+}
+
+func MustNewTuple(values ...Value) Value {
+	tuple, err := NewTuple(values...)
+	if err != nil {
+		panic(err)
+	}
+	return tuple
+}
+
+// NewTuple creates a tuple of base values. Components can be undefined.
+func NewTuple(values ...Value) (Value, error) {
+	if len(values) == 0 {
+		return nil, fmt.Errorf("tuple must have at least one value")
+	}
+	for _, value := range values {
+		if _, ok := value.(*Undefined); !ok && !isBaseType(value.Type()) {
+			return nil, fmt.Errorf("tuple cannot contain %s", value.Type())
+		}
+	}
+	return &Tuple{values}, nil
+}
+
+func (value *Tuple) Type() Type {
+	elementTypes := make([]Type, len(value.values))
+	for i, v := range value.values {
+		elementTypes[i] = v.Type()
+	}
+	return &TupleType{elementTypes}
+}
+
+// Values returns the components of this tuple.
+func (value *Tuple) Values() []Value {
+	return value.values
+}
+
+func (value *Tuple) Equal(that Value) bool {
+	typed, ok := that.(*Tuple)
+	if !ok || len(value.values) != len(typed.values) {
+		return false
+	}
+	for i := range value.values {
+		if !value.values[i].Equal(typed.values[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (value *Tuple) String() string {
+	parts := make([]string, len(value.values))
+	for i, v := range value.values {
+		parts[i] = v.String()
+	}
+	return fmt.Sprintf("tuple(%s)", strings.Join(parts, ", "))
+}
+
+// Date returns the date of this instant in time, in the location provided.
+func (value *Time) Date(loc *time.Location) *Date {
+	year, month, day := value.value.In(loc).Date()
+	return NewDate(year, month, day).(*Date)
+}
+
+func (value *Time) add(d *duration) *Time {
+	switch d.unit {
+	case "year":
+		return &Time{addMonths(value.value, int(12*d.amount))}
+	case "month":
+		return &Time{addMonths(value.value, int(d.amount))}
+	case "week":
+		return &Time{value.value.AddDate(0, 0, int(7*d.amount))}
+	case "day":
+		return &Time{value.value.AddDate(0, 0, int(d.amount))}
+	case "hour":
+		return &Time{value.value.Add(time.Duration(d.amount) * time.Hour)}
+	case "minute":
+		return &Time{value.value.Add(time.Duration(d.amount) * time.Minute)}
+	case "second":
+		return &Time{value.value.Add(time.Duration(d.amount) * time.Second)}
+	default:
+		panic(fmt.Sprintf("unknown duration unit %s", d.unit))
+	}
+}
+
+// addMonths adds months to t. Unlike time.AddDate which normalizes dates
+// overflowing a month, we clamp to the last day of the month. For instance,
+// 1/31 + 1 month is 2/28 (or 2/29), rather than 3/3.
+func addMonths(t time.Time, months int) time.Time {
+	year, month, day := t.Date()
+	hour, min, sec := t.Clock()
+	first := time.Date(year, month+time.Month(months), 1, hour, min, sec, t.Nanosecond(), t.Location())
+	if last := first.AddDate(0, 1, -1).Day(); last < day {
+		day = last
+	}
+	return time.Date(first.Year(), first.Month(), day, hour, min, sec, t.Nanosecond(), t.Location())
+}
+
+func (value *duration) Type() Type {
+	return &tDurationType{}
+}
+
+func (value *duration) Equal(that Value) bool {
+	typed, ok := that.(*duration)
+	if !ok {
+		return false
+	}
+	return value.amount == typed.amount && value.unit == typed.unit
+}
+
+func (value *duration) String() string {
+	return fmt.Sprintf("%d %ss", value.amount, value.unit)
+}
+
+func (value *duration) negate() *duration {
+	return &duration{-value.amount, value.unit}
+}
+
 type sliceElement struct {
 	rank  int
 	value Value
@@ -337,15 +736,21 @@ func (value *slice) doAppend(element Value) (*slice, error) {
 	if !element.Type().AssignableTo(value.typ.elementType) {
 		return nil, fmt.Errorf("cannot append %s to %s", element.Type(), value.Type())
 	}
+	if err := checkEnumMembership(element, value.typ.elementType); err != nil {
+		return nil, err
+	}
 
+	// Slices are immutable, we therefore copy elements rather than appending
+	// to the possibly shared backing array.
 	nextRank := value.lastRank + 1
-	value.lastRank++
+	elements := make([]sliceElement, len(value.elements), len(value.elements)+1)
+	copy(elements, value.elements)
 
 	slice := &slice{
 		id:       value.id,
 		typ:      value.typ,
-		lastRank: value.lastRank,
-		elements: append(value.elements, sliceElement{
+		lastRank: nextRank,
+		elements: append(elements, sliceElement{
 			rank:  nextRank,
 			value: element,
 		}),
@@ -397,6 +802,125 @@ func (value *slice) String() string {
 	return buffer.String()
 }
 
+type mapEntry struct {
+	rank  int
+	key   string
+	value *Worksheet
+}
+
+func (entry mapEntry) String() string {
+	return fmt.Sprintf("%d:%s", entry.rank, entry.value)
+}
+
+// mapValue is a collection of worksheets indexed by their key. Entries are
+// kept in the order they were first put in the map, and ranked like slice
+// elements.
+type mapValue struct {
+	id       string
+	lastRank int
+	typ      *MapType
+	entries  []mapEntry
+}
+
+func newMap(typ *MapType) *mapValue {
+	return &mapValue{
+		id:  uuid.NewV4().String(),
+		typ: typ,
+	}
+}
+
+func newMapWithIdAndLastRank(typ *MapType, id string, lastRank int) *mapValue {
+	return &mapValue{
+		id:       id,
+		typ:      typ,
+		lastRank: lastRank,
+	}
+}
+
+// doPut puts the worksheet in the map under key. Putting a worksheet under a
+// key already present replaces the worksheet in place.
+func (value *mapValue) doPut(key string, element Value) (*mapValue, error) {
+	if !element.Type().AssignableTo(value.typ.elementType) {
+		return nil, fmt.Errorf("cannot put %s in %s", element.Type(), value.Type())
+	}
+
+	entries := make([]mapEntry, len(value.entries), len(value.entries)+1)
+	copy(entries, value.entries)
+
+	lastRank := value.lastRank
+	if i := value.find(key); i != -1 {
+		entries[i].value = element.(*Worksheet)
+	} else {
+		lastRank++
+		entries = append(entries, mapEntry{
+			rank:  lastRank,
+			key:   key,
+			value: element.(*Worksheet),
+		})
+	}
+
+	return &mapValue{
+		id:       value.id,
+		typ:      value.typ,
+		lastRank: lastRank,
+		entries:  entries,
+	}, nil
+}
+
+func (value *mapValue) doDelKey(key string) (*mapValue, error) {
+	i := value.find(key)
+	if i == -1 {
+		return nil, fmt.Errorf("no worksheet with key %s", key)
+	}
+
+	entries := make([]mapEntry, 0, len(value.entries)-1)
+	entries = append(entries, value.entries[:i]...)
+	entries = append(entries, value.entries[i+1:]...)
+
+	return &mapValue{
+		id:       value.id,
+		typ:      value.typ,
+		lastRank: value.lastRank,
+		entries:  entries,
+	}, nil
+}
+
+func (value *mapValue) find(key string) int {
+	if value == nil {
+		return -1
+	}
+	for i, entry := range value.entries {
+		if entry.key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func (value *mapValue) Type() Type {
+	return value.typ
+}
+
+func (value *mapValue) Equal(that Value) bool {
+	// Like slices, maps are immutable and compared by pointer equality.
+	return value == that
+}
+
+func (value *mapValue) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteRune('{')
+	for i, entry := range value.entries {
+		if i != 0 {
+			buffer.WriteRune(' ')
+		}
+		buffer.WriteString(entry.key)
+		buffer.WriteRune(':')
+		buffer.WriteString(entry.value.String())
+	}
+	buffer.WriteRune('}')
+	return buffer.String()
+}
+
 func (ws *Worksheet) Type() Type {
 	return ws.def
 }
@@ -13,7 +13,10 @@
 package worksheets
 
 import (
+	"time"
+
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func (s *Zuite) TestValueString() {
@@ -35,6 +38,11 @@ func (s *Zuite) TestValueString() {
 		&Number{123, &tNumberType{3}}:   "0.123",
 		&Number{123, &tNumberType{4}}:   "0.0123",
 
+		NewDate(1969, time.July, 20): `date("1969-07-20")`,
+
+		NewTime(time.Date(1969, time.July, 20, 20, 17, 40, 0, time.UTC)):      `time("1969-07-20T20:17:40Z")`,
+		NewTime(time.Date(2018, time.January, 1, 1, 2, 3, 4000000, time.UTC)): `time("2018-01-01T01:02:03.004Z")`,
+
 		&slice{elements: []sliceElement{
 			{value: &Number{123, &tNumberType{1}}},
 		}}: "[12.3]",
@@ -43,6 +51,8 @@ func (s *Zuite) TestValueString() {
 			{value: &Bool{false}},
 		}}: "[true false]",
 
+		MustNewTuple(alice, &Number{123, &tNumberType{2}}, NewUndefined()): `tuple("Alice", 1.23, undefined)`,
+
 		ws: `worksheet[age:73 name:"Alice"]`,
 	}
 	for value, expected := range cases {
@@ -81,6 +91,33 @@ func (s *Zuite) TestValueEqual() {
 			NewBool(false),
 			NewBool(false),
 		},
+		{
+			NewDate(1969, time.July, 20),
+			MustNewValue(`date("1969-07-20")`),
+		},
+		{
+			NewDate(1969, time.July, 21),
+		},
+		{
+			NewTime(time.Date(1969, time.July, 20, 20, 17, 40, 0, time.UTC)),
+			NewTime(time.Date(1969, time.July, 20, 16, 17, 40, 0, time.FixedZone("EDT", -4*60*60))),
+			MustNewValue(`time("1969-07-20T20:17:40Z")`),
+			MustNewValue(`time("1969-07-20T16:17:40-04:00")`),
+		},
+		{
+			MustNewTuple(alice, MustNewValue("1")),
+			MustNewValue(`tuple("Alice", 1)`),
+		},
+		{
+			MustNewTuple(alice, MustNewValue("1.0")),
+		},
+		{
+			MustNewTuple(alice),
+		},
+		{
+			MustNewTuple(alice, NewUndefined()),
+			MustNewValue(`tuple("Alice", undefined)`),
+		},
 	}
 
 	// all values must be equal within a bucket
@@ -125,12 +162,19 @@ func (s *Zuite) TestNumber_Plus() {
 			right:    MustNewValue("3").(*Number),
 			expected: MustNewValue("5.0").(*Number),
 		},
+		{
+			left:     MustNewValue("5.03").(*Number),
+			right:    MustNewValue("6.000").(*Number),
+			expected: MustNewValue("11.030").(*Number),
+		},
 	}
 	for _, ex := range cases {
-		actual := ex.left.Plus(ex.right)
+		actual, err := ex.left.Plus(ex.right)
+		require.NoError(s.T(), err)
 		assert.Equal(s.T(), ex.expected, actual, "%s + %s", ex.left, ex.right)
 
-		actual = ex.right.Plus(ex.left)
+		actual, err = ex.right.Plus(ex.left)
+		require.NoError(s.T(), err)
 		assert.Equal(s.T(), ex.expected, actual, "%s + %s", ex.right, ex.left)
 	}
 }
@@ -149,9 +193,15 @@ func (s *Zuite) TestNumber_Minus() {
 			right:    MustNewValue("3").(*Number),
 			expected: MustNewValue("-1.0").(*Number),
 		},
+		{
+			left:     MustNewValue("5.03").(*Number),
+			right:    MustNewValue("6.000").(*Number),
+			expected: MustNewValue("-0.970").(*Number),
+		},
 	}
 	for _, ex := range cases {
-		actual := ex.left.Minus(ex.right)
+		actual, err := ex.left.Minus(ex.right)
+		require.NoError(s.T(), err)
 		assert.Equal(s.T(), ex.expected, actual, "%s + %s", ex.left, ex.right)
 	}
 }
@@ -177,14 +227,46 @@ func (s *Zuite) TestNumber_Mult() {
 		},
 	}
 	for _, ex := range cases {
-		actual := ex.left.Mult(ex.right)
+		actual, err := ex.left.Mult(ex.right)
+		require.NoError(s.T(), err)
 		assert.Equal(s.T(), ex.expected, actual, "%s + %s", ex.left, ex.right)
 
-		actual = ex.right.Mult(ex.left)
+		actual, err = ex.right.Mult(ex.left)
+		require.NoError(s.T(), err)
 		assert.Equal(s.T(), ex.expected, actual, "%s + %s", ex.right, ex.left)
 	}
 }
 
+func (s *Zuite) TestNumber_IntDivAndMod() {
+	cases := []struct {
+		left, right, quotient, remainder string
+	}{
+		{"7", "2", "3", "1"},
+		{"-7", "2", "-3", "-1"},
+		{"7", "-2", "-3", "1"},
+		{"7.5", "2", "3", "1.5"},
+		{"7", "2.50", "2", "2.00"},
+		{"0.3", "0.1", "3", "0.0"},
+	}
+	for _, ex := range cases {
+		left, right := MustNewValue(ex.left).(*Number), MustNewValue(ex.right).(*Number)
+
+		quotient, err := left.IntDiv(right)
+		require.NoError(s.T(), err)
+		assert.Equal(s.T(), MustNewValue(ex.quotient), quotient, "%s div %s", left, right)
+
+		remainder, err := left.Mod(right)
+		require.NoError(s.T(), err)
+		assert.Equal(s.T(), MustNewValue(ex.remainder), remainder, "%s %% %s", left, right)
+	}
+
+	_, err := MustNewValue("7").(*Number).IntDiv(MustNewValue("0.00").(*Number))
+	require.EqualError(s.T(), err, "division by zero")
+
+	_, err = MustNewValue("7").(*Number).Mod(MustNewValue("0").(*Number))
+	require.EqualError(s.T(), err, "modulo by zero")
+}
+
 func (s *Zuite) TestNumber_Round() {
 	cases := []struct {
 		value, expected *Number
@@ -262,13 +344,49 @@ func (s *Zuite) TestNumber_Round() {
 		},
 	}
 	for _, ex := range cases {
-		actual := ex.value.Round(ex.round.mode, ex.round.scale)
+		actual, err := ex.value.Round(ex.round.mode, ex.round.scale)
+		require.NoError(s.T(), err)
 		assert.Equal(s.T(), ex.expected, actual,
 			"%s round %s %d should equal %s",
 			ex.value, ex.round.mode, ex.round.scale, ex.expected)
 	}
 }
 
+func (s *Zuite) TestNumber_RoundingModes() {
+	// This mirrors the table documented on RoundingMode.
+	var (
+		values = []string{"2.5", "1.5", "1.2", "-1.2", "-1.5", "-2.5"}
+		cases  = map[RoundingMode][]string{
+			ModeUp:       {"3", "2", "2", "-2", "-2", "-3"},
+			ModeDown:     {"2", "1", "1", "-1", "-1", "-2"},
+			ModeCeiling:  {"3", "2", "2", "-1", "-1", "-2"},
+			ModeFloor:    {"2", "1", "1", "-2", "-2", "-3"},
+			ModeHalf:     {"3", "2", "1", "-1", "-2", "-3"},
+			ModeHalfDown: {"2", "1", "1", "-1", "-1", "-2"},
+			ModeEven:     {"2", "2", "1", "-1", "-2", "-2"},
+		}
+	)
+	for mode, expected := range cases {
+		for i, value := range values {
+			actual, err := MustNewValue(value).(*Number).Round(mode, 0)
+			require.NoError(s.T(), err)
+			assert.Equal(s.T(), MustNewValue(expected[i]), actual, "%s round %s 0", value, mode)
+		}
+	}
+
+	// ties are only ties when exact
+	actual, err := MustNewValue("2.501").(*Number).Round(ModeHalfDown, 0)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), MustNewValue("3"), actual)
+
+	actual, err = MustNewValue("2.501").(*Number).Round(ModeEven, 0)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), MustNewValue("3"), actual)
+
+	_, err = MustNewValue("2.5").(*Number).Round(RoundingMode("sideways"), 0)
+	assert.EqualError(s.T(), err, "unknown rounding mode sideways")
+}
+
 func (s *Zuite) TestNumber_Div() {
 	cases := []struct {
 		left, right, expected *Number
@@ -319,7 +437,7 @@ func (s *Zuite) TestNumber_Div() {
 		{
 			left:     MustNewValue("7").(*Number),
 			right:    MustNewValue("1.23").(*Number),
-			expected: MustNewValue("5.691").(*Number),
+			expected: MustNewValue("5.692").(*Number),
 			round:    &tRound{"up", 3},
 		},
 		{
@@ -394,11 +512,169 @@ func (s *Zuite) TestNumber_Div() {
 			expected: MustNewValue("-3.1532").(*Number),
 			round:    &tRound{"half", 4},
 		},
+		{
+			left:     MustNewValue("2").(*Number),
+			right:    MustNewValue("1.9").(*Number),
+			expected: MustNewValue("2").(*Number),
+			round:    &tRound{"up", 0},
+		},
+		{
+			left:     MustNewValue("-7").(*Number),
+			right:    MustNewValue("2").(*Number),
+			expected: MustNewValue("-4").(*Number),
+			round:    &tRound{"floor", 0},
+		},
+		{
+			left:     MustNewValue("7").(*Number),
+			right:    MustNewValue("-2").(*Number),
+			expected: MustNewValue("-3").(*Number),
+			round:    &tRound{"ceiling", 0},
+		},
+		{
+			left:     MustNewValue("5").(*Number),
+			right:    MustNewValue("2").(*Number),
+			expected: MustNewValue("2").(*Number),
+			round:    &tRound{"even", 0},
+		},
+		{
+			left:     MustNewValue("-5").(*Number),
+			right:    MustNewValue("2").(*Number),
+			expected: MustNewValue("-2").(*Number),
+			round:    &tRound{"half_down", 0},
+		},
+		{
+			left:     MustNewValue("1").(*Number),
+			right:    MustNewValue("3").(*Number),
+			expected: MustNewValue("0.33").(*Number),
+			round:    &tRound{"even", 2},
+		},
 	}
 	for _, ex := range cases {
-		actual := ex.left.Div(ex.right, ex.round.mode, ex.round.scale)
+		actual, err := ex.left.Div(ex.right, ex.round.mode, ex.round.scale)
+		require.NoError(s.T(), err)
 		assert.Equal(s.T(), ex.expected, actual,
 			"%s / %s round %s %d should equal %s",
 			ex.left, ex.right, ex.round.mode, ex.round.scale, ex.expected)
 	}
 }
+
+func (s *Zuite) TestNumber_overflow() {
+	var (
+		big   = MustNewValue("9223372036854775807").(*Number)
+		small = MustNewValue("0.0000000001").(*Number)
+		one   = MustNewValue("1").(*Number)
+		zero  = MustNewValue("0").(*Number)
+	)
+
+	_, err := big.Plus(one)
+	assert.EqualError(s.T(), err, "number[0] overflow")
+
+	_, err = big.Minus(MustNewValue("-1").(*Number))
+	assert.EqualError(s.T(), err, "number[0] overflow")
+
+	_, err = big.Mult(MustNewValue("2").(*Number))
+	assert.EqualError(s.T(), err, "number[0] overflow")
+
+	_, err = small.Mult(small)
+	require.NoError(s.T(), err)
+
+	_, err = MustNewValue("1000000.0000000001").(*Number).Mult(MustNewValue("1000000.0000000001").(*Number))
+	assert.EqualError(s.T(), err, "number[20] overflow")
+
+	_, err = big.Round(ModeDown, 1)
+	assert.EqualError(s.T(), err, "number[1] overflow")
+
+	_, err = big.Div(zero, ModeDown, 2)
+	assert.EqualError(s.T(), err, "division by zero")
+
+	// intermediate results exceeding the representation are fine, as long
+	// as the result fits
+	actual, err := big.Div(MustNewValue("2").(*Number), ModeDown, 0)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), MustNewValue("4611686018427387903"), actual)
+
+	actual, err = big.Round(ModeHalf, 0)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), big, actual)
+
+	require.Equal(s.T(), 1, big.compare(MustNewValue("0.000000000000000001").(*Number)))
+}
+
+func (s *Zuite) TestDateAndTime_roundTrip() {
+	cases := []Value{
+		NewDate(1969, time.July, 20),
+		NewDate(2000, time.February, 29),
+		NewTime(time.Date(1969, time.July, 20, 20, 17, 40, 0, time.UTC)),
+		NewTime(time.Date(2018, time.January, 1, 1, 2, 3, 4000000, time.UTC)),
+	}
+	for _, value := range cases {
+		actual, err := NewValue(value.String())
+		require.NoError(s.T(), err, value.String())
+		assert.Equal(s.T(), value, actual)
+	}
+}
+
+func (s *Zuite) TestDateAndTime_beforeAndAfter() {
+	var (
+		d1 = NewDate(1969, time.July, 20).(*Date)
+		d2 = NewDate(1969, time.July, 21).(*Date)
+		t1 = NewTime(time.Date(1969, time.July, 20, 20, 17, 40, 0, time.UTC)).(*Time)
+		t2 = NewTime(time.Date(1969, time.July, 20, 20, 17, 41, 0, time.UTC)).(*Time)
+	)
+
+	assert.True(s.T(), d1.Before(d2))
+	assert.False(s.T(), d2.Before(d1))
+	assert.False(s.T(), d1.Before(d1))
+	assert.True(s.T(), d2.After(d1))
+	assert.False(s.T(), d1.After(d2))
+
+	assert.True(s.T(), t1.Before(t2))
+	assert.False(s.T(), t2.Before(t1))
+	assert.True(s.T(), t2.After(t1))
+	assert.False(s.T(), t1.After(t1))
+}
+
+func (s *Zuite) TestDateAndTime_literalErrors() {
+	cases := map[string]string{
+		`date("1969-13-01")`:          `invalid date 1969-13-01`,
+		`date("7/20/1969")`:           `invalid date 7/20/1969`,
+		`time("1969-07-20")`:          `invalid time 1969-07-20`,
+		`time("1969-07-20T20:17:40")`: `invalid time 1969-07-20T20:17:40`,
+		`date(1969)`:                  `expected text, found 1969`,
+	}
+	for input, msg := range cases {
+		_, err := NewValue(input)
+		assert.EqualError(s.T(), err, msg, input)
+	}
+}
+
+func (s *Zuite) TestTuple_literals() {
+	cases := map[string]Value{
+		`tuple(1)`:                             &Tuple{[]Value{&Number{1, &tNumberType{0}}}},
+		`tuple("Alice", -1.5, true)`:           &Tuple{[]Value{alice, &Number{-15, &tNumberType{1}}, &Bool{true}}},
+		`tuple(date("1969-07-20"), undefined)`: &Tuple{[]Value{NewDate(1969, time.July, 20), &Undefined{}}},
+	}
+	for input, expected := range cases {
+		actual, err := NewValue(input)
+		require.NoError(s.T(), err, input)
+		assert.Equal(s.T(), expected, actual, input)
+		assert.Equal(s.T(), input, actual.String(), input)
+	}
+
+	errors := map[string]string{
+		`tuple()`:         `unknown literal, found )`,
+		`tuple(1,)`:       `unknown literal, found )`,
+		`tuple(1 "a")`:    `expected ), found "a"`,
+		`tuple(tuple(1))`: `tuple cannot contain tuple[number[0]]`,
+	}
+	for input, msg := range errors {
+		_, err := NewValue(input)
+		assert.EqualError(s.T(), err, msg, input)
+	}
+
+	_, err := NewTuple(alice, &slice{typ: &SliceType{&tTextType{}}})
+	assert.EqualError(s.T(), err, "tuple cannot contain []text")
+
+	_, err = NewTuple()
+	assert.EqualError(s.T(), err, "tuple must have at least one value")
+}
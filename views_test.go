@@ -0,0 +1,189 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worksheets
+
+import (
+	"strings"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+var viewsDefs = MustNewDefinitions(strings.NewReader(`
+view income {
+	amount number[2]
+	payer text
+}
+
+view named {
+	name text
+}
+
+worksheet salary implements income, named {
+	1:name text
+	2:payer text
+	3:amount number[2]
+}
+
+worksheet rental implements income {
+	1:payer text
+	2:amount number[0]
+	3:address text
+}
+
+worksheet gift {
+	1:payer text
+	2:amount number[2]
+}
+
+worksheet tax_return {
+	1:main_income income
+	2:incomes []income
+}`))
+
+func (s *Zuite) TestViews_parse() {
+	var (
+		income = viewsDefs.defs["salary"].implements[0]
+		named  = viewsDefs.defs["salary"].implements[1]
+		salary = viewsDefs.defs["salary"]
+		rental = viewsDefs.defs["rental"]
+		gift   = viewsDefs.defs["gift"]
+	)
+
+	require.Equal(s.T(), "income", income.String())
+	require.Equal(s.T(), []string{"amount", "payer"}, []string{income.fields[0].name, income.fields[1].name})
+	require.Equal(s.T(), &tNumberType{2}, income.FieldByName("amount").Type())
+	require.Equal(s.T(), "named", named.String())
+
+	require.Equal(s.T(), []*View{income}, rental.implements)
+	require.Empty(s.T(), gift.implements)
+
+	returnDef := viewsDefs.defs["tax_return"]
+	require.Equal(s.T(), income, returnDef.fieldsByName["main_income"].typ)
+	require.Equal(s.T(), &SliceType{income}, returnDef.fieldsByName["incomes"].typ)
+	require.Equal(s.T(), "[]income", returnDef.fieldsByName["incomes"].typ.String())
+
+	require.True(s.T(), salary.AssignableTo(income))
+	require.True(s.T(), salary.AssignableTo(named))
+	require.True(s.T(), rental.AssignableTo(income))
+	require.False(s.T(), rental.AssignableTo(named))
+	require.False(s.T(), gift.AssignableTo(income))
+	require.False(s.T(), income.AssignableTo(salary))
+	require.True(s.T(), income.AssignableTo(income))
+	require.False(s.T(), income.AssignableTo(named))
+}
+
+func (s *Zuite) TestViews_setAndAppend() {
+	var (
+		ws     = viewsDefs.MustNewWorksheet("tax_return")
+		salary = viewsDefs.MustNewWorksheet("salary")
+		rental = viewsDefs.MustNewWorksheet("rental")
+		gift   = viewsDefs.MustNewWorksheet("gift")
+	)
+
+	ws.MustSet("main_income", salary)
+	require.Equal(s.T(), salary, ws.MustGet("main_income"))
+	ws.MustSet("main_income", rental)
+	require.Equal(s.T(), rental, ws.MustGet("main_income"))
+
+	ws.MustAppend("incomes", salary)
+	ws.MustAppend("incomes", rental)
+	require.Equal(s.T(), []Value{salary, rental}, ws.MustGetSlice("incomes"))
+
+	err := ws.Set("main_income", gift)
+	require.EqualError(s.T(), err, "cannot assign value of type gift to field of type income")
+
+	err = ws.Append("incomes", gift)
+	require.EqualError(s.T(), err, "cannot append gift to []income")
+}
+
+func (s *Zuite) TestViews_definitionErrors() {
+	cases := map[string]string{
+		`worksheet w implements unknown {}`: `1:1: w: implements unknown view unknown`,
+
+		`view v {}
+		worksheet w implements v, v {}`: `2:3: w: implements view v more than once`,
+
+		`view v {name text}
+		worksheet w implements v {}`: `2:3: w: does not implement v, missing field name`,
+
+		`view v {name text}
+		worksheet w implements v {1:name bool}`: `2:29: w: does not implement v, field name is bool not text`,
+
+		`view v {amount number[0]}
+		worksheet w implements v {1:amount number[2]}`: `2:29: w: does not implement v, field amount is number[2] not number[0]`,
+
+		`view v {name text name bool}
+		worksheet w {}`: `1:24: v.name: multiple fields named name`,
+
+		`view v {other unknown}
+		worksheet w {}`: `1:9: v.other: unknown worksheet unknown referenced`,
+
+		`view v {}
+		view v {}
+		worksheet w {}`: `2:3: multiple views with name v`,
+
+		`view v {}
+		worksheet v {}`: `2:3: worksheet v has the same name as a view`,
+
+		`worksheet v {}
+		view v {}`: `2:3: view v has the same name as a worksheet`,
+
+		`view v {}
+		worksheet w {1:m map[v]}`: `2:16: w.m: map of view v, maps require keyed worksheets`,
+
+		`view v {}`: `1:10: expecting worksheet`,
+	}
+	for input, msg := range cases {
+		_, err := NewDefinitions(strings.NewReader(input))
+		assert.EqualError(s.T(), err, msg, input)
+	}
+}
+
+func (s *DbZuite) TestViews_saveLoadMixedRefs() {
+	var (
+		ws       = defs.MustNewWorksheet("with_named")
+		simple   = defs.MustNewWorksheet("simple")
+		withName = defs.MustNewWorksheet("keyed_by_name")
+	)
+	simple.MustSet("name", alice)
+	withName.MustSet("name", bob)
+	ws.MustSet("first", withName)
+	ws.MustAppend("all", simple)
+	ws.MustAppend("all", withName)
+
+	s.MustRunTransaction(func(tx *runner.Tx) error {
+		session := s.store.Open(tx)
+		return session.Save(ws)
+	})
+
+	var fresh *Worksheet
+	s.MustRunTransaction(func(tx *runner.Tx) error {
+		session := s.store.Open(tx)
+		var err error
+		fresh, err = session.Load(ws.Id())
+		return err
+	})
+
+	freshFirst := fresh.MustGet("first").(*Worksheet)
+	require.Equal(s.T(), "keyed_by_name", freshFirst.Name())
+	require.Equal(s.T(), withName.Id(), freshFirst.Id())
+
+	all := fresh.MustGetSlice("all")
+	require.Len(s.T(), all, 2)
+	require.Equal(s.T(), "simple", all[0].(*Worksheet).Name())
+	require.Equal(s.T(), alice, all[0].(*Worksheet).MustGet("name"))
+	require.Equal(s.T(), "keyed_by_name", all[1].(*Worksheet).Name())
+	require.Equal(s.T(), bob, all[1].(*Worksheet).MustGet("name"))
+}
@@ -15,7 +15,12 @@ package worksheets
 import (
 	"fmt"
 	"io"
+	"io/fs"
+	"path"
+	"sort"
 	"strconv"
+	"strings"
+	"text/scanner"
 
 	"github.com/satori/go.uuid"
 )
@@ -39,6 +44,14 @@ type Worksheet struct {
 
 	// data holds all the worksheet data.
 	data map[int]Value
+
+	// frozen is set while the worksheet is in a map, during which fields
+	// forming its key cannot be edited.
+	frozen bool
+
+	// parents holds the worksheets referencing this worksheet, along with the
+	// number of references held by each of their fields.
+	parents map[*Worksheet]map[int]int
 }
 
 const (
@@ -54,10 +67,22 @@ type ComputedBy interface {
 	Compute(...Value) Value
 }
 
+// Reactor is user code intercepting edits, and possibly amending them. It is
+// called during the fixed-point calculation of edits, with the worksheet as it
+// would be were the proposed edit applied. The worksheet must not be modified,
+// changes must be expressed by returning an amended edit.
+type Reactor interface {
+	OnEdit(current *Worksheet, proposed *Edit) (*Edit, error)
+}
+
 type Options struct {
 	// Plugins is a map of workshet names, to field names, to plugins for
 	// externally computed fields.
 	Plugins map[string]map[string]ComputedBy
+
+	// Reactors is a map of worksheet names, to reactors called on every edit
+	// of worksheets of that name.
+	Reactors map[string][]Reactor
 }
 
 func MustNewDefinitions(reader io.Reader, opts ...Options) *Definitions {
@@ -68,22 +93,184 @@ func MustNewDefinitions(reader io.Reader, opts ...Options) *Definitions {
 	return defs
 }
 
+// DefinitionError is an error found in worksheet definitions, along with the
+// position at which it occurs.
+type DefinitionError struct {
+	// Pos holds the file, line, and column of the error.
+	Pos scanner.Position
+
+	// Msg holds the description of the error.
+	Msg string
+}
+
+func definitionErrorf(pos scanner.Position, format string, args ...interface{}) *DefinitionError {
+	return &DefinitionError{
+		Pos: pos,
+		Msg: fmt.Sprintf(format, args...),
+	}
+}
+
+func (e *DefinitionError) Error() string {
+	if !e.Pos.IsValid() {
+		return e.Msg
+	} else if e.Pos.Filename == "" {
+		return fmt.Sprintf("%d:%d: %s", e.Pos.Line, e.Pos.Column, e.Msg)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", e.Pos.Filename, e.Pos.Line, e.Pos.Column, e.Msg)
+}
+
+// DefinitionErrors holds all errors found in worksheet definitions, which are
+// reported together.
+type DefinitionErrors []*DefinitionError
+
+func (errs *DefinitionErrors) add(pos scanner.Position, format string, args ...interface{}) {
+	*errs = append(*errs, definitionErrorf(pos, format, args...))
+}
+
+// err returns the errors ordered by position, or nil if there are none.
+func (errs DefinitionErrors) err() error {
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.SliceStable(errs, func(i, j int) bool {
+		p, q := errs[i].Pos, errs[j].Pos
+		if p.Filename != q.Filename {
+			return p.Filename < q.Filename
+		} else if p.Line != q.Line {
+			return p.Line < q.Line
+		}
+		return p.Column < q.Column
+	})
+	return errs
+}
+
+func (errs DefinitionErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
 // NewDefinitions parses one or more worksheet definitions, and creates worksheet
 // models from them.
+//
+// Problems found in the definitions are reported as DefinitionErrors, listing
+// all errors found in a pass rather than stopping at the first one.
 func NewDefinitions(reader io.Reader, opts ...Options) (*Definitions, error) {
-	p := newParser(reader)
-	defs, err := p.parseWorksheets()
+	var (
+		p  = newParser(reader)
+		ns = newNamespace()
+	)
+	imports, errs := p.parseWorksheets(ns)
+	for _, imp := range imports {
+		errs.add(imp.pos, "import %q: imports require definitions read from files", imp.path)
+	}
+	if err := errs.err(); err != nil {
+		return nil, err
+	} else if len(ns.defs) == 0 {
+		errs.add(p.pos, "expecting worksheet")
+		return nil, errs.err()
+	}
+
+	return ns.newDefinitions(opts...)
+}
+
+func MustNewDefinitionsFromFiles(fsys fs.FS, filenames []string, opts ...Options) *Definitions {
+	defs, err := NewDefinitionsFromFiles(fsys, filenames, opts...)
 	if err != nil {
+		panic(err)
+	}
+	return defs
+}
+
+// NewDefinitionsFromFiles parses the worksheet definitions of files, along with
+// the files they import, and creates worksheet models from them. Imports are
+// relative to the importing file. Each file is read once, such that files can
+// import one another, and definitions of all files share one namespace.
+//
+// Errors are reported as with NewDefinitions, their positions naming the file
+// in which they occur.
+func NewDefinitionsFromFiles(fsys fs.FS, filenames []string, opts ...Options) (*Definitions, error) {
+	var (
+		ns     = newNamespace()
+		loaded = make(map[string]bool)
+		errs   DefinitionErrors
+		load   func(filename string, from *tImport)
+	)
+	load = func(filename string, from *tImport) {
+		if loaded[filename] {
+			return
+		}
+		loaded[filename] = true
+
+		file, err := fsys.Open(filename)
+		if err != nil {
+			var pos scanner.Position
+			if from != nil {
+				pos = from.pos
+			}
+			errs.add(pos, "%s", err)
+			return
+		}
+		p := newParser(file)
+		p.s.Filename = filename
+		imports, fileErrs := p.parseWorksheets(ns)
+		file.Close()
+
+		errs = append(errs, fileErrs...)
+		for _, imp := range imports {
+			load(path.Join(path.Dir(filename), imp.path), imp)
+		}
+	}
+	for _, filename := range filenames {
+		load(path.Clean(filename), nil)
+	}
+
+	if err := errs.err(); err != nil {
 		return nil, err
-	} else if p.next() != "" || len(defs) == 0 {
-		return nil, fmt.Errorf("expecting worksheet")
+	} else if len(ns.defs) == 0 {
+		errs.add(scanner.Position{}, "expecting worksheet")
+		return nil, errs.err()
 	}
 
-	err = processOptions(defs, opts...)
+	return ns.newDefinitions(opts...)
+}
+
+// newDefinitions validates the worksheets, views, and enums of the namespace,
+// resolving references among them, and creates worksheet models from them.
+func (ns *namespace) newDefinitions(opts ...Options) (*Definitions, error) {
+	var (
+		defs  = ns.defs
+		views = ns.views
+		enums = ns.enums
+		errs  DefinitionErrors
+	)
+
+	err := processOptions(defs, opts...)
 	if err != nil {
 		return nil, err
 	}
 
+	// Worksheets, views, and enums can all be referenced by name.
+	refs := make(map[string]Type)
+	for name, def := range defs {
+		refs[name] = def
+	}
+	for name, view := range views {
+		refs[name] = view
+	}
+	for name, enum := range enums {
+		refs[name] = enum
+	}
+	for _, view := range views {
+		for _, field := range view.fields {
+			if err := resolveRefTypes(fmt.Sprintf("%s.%s", view.name, field.name), refs, field); err != nil {
+				errs.add(field.pos, "%s", err)
+			}
+		}
+	}
+
 	for _, def := range defs {
 		var (
 			indexesUsed = make(map[int]bool)
@@ -92,32 +279,58 @@ func NewDefinitions(reader io.Reader, opts ...Options) (*Definitions, error) {
 		for _, field := range def.fields {
 			// Any bad index?
 			if field.index == 0 {
-				return nil, fmt.Errorf("%s.%s: index cannot be zero", def.name, field.name)
+				errs.add(field.pos, "%s.%s: index cannot be zero", def.name, field.name)
+				continue
 			}
 
 			// Any index reused?
 			if _, ok := indexesUsed[field.index]; ok {
-				return nil, fmt.Errorf("%s.%s: index %d cannot be reused", def.name, field.name, field.index)
+				errs.add(field.pos, "%s.%s: index %d cannot be reused", def.name, field.name, field.index)
+				continue
 			}
 			indexesUsed[field.index] = true
 
 			// Any names reused?
 			if _, ok := namesUsed[field.name]; ok {
-				return nil, fmt.Errorf("%s.%s: multiple fields named %s", def.name, field.name, field.name)
+				errs.add(field.pos, "%s.%s: multiple fields named %s", def.name, field.name, field.name)
+				continue
 			}
 			namesUsed[field.name] = true
 
 			// Any unresolved externals?
 			if _, ok := field.computedBy.(*tExternal); ok {
-				return nil, fmt.Errorf("%s.%s: missing plugin for external computed_by", def.name, field.name)
+				errs.add(field.pos, "%s.%s: missing plugin for external computed_by", def.name, field.name)
+				continue
+			}
+
+			// Constraints apply to input fields only, and cannot be external.
+			if field.constrainedBy != nil {
+				if field.computedBy != nil {
+					errs.add(field.pos, "%s.%s: computed fields cannot be constrained", def.name, field.name)
+					continue
+				}
+				if _, ok := field.constrainedBy.(*tExternal); ok {
+					errs.add(field.pos, "%s.%s: constrained_by cannot be external", def.name, field.name)
+					continue
+				}
 			}
 
 			// Any unknown refs types?
-			if err := resolveRefTypes(fmt.Sprintf("%s.%s", def.name, field.name), defs, field); err != nil {
-				return nil, err
+			if err := resolveRefTypes(fmt.Sprintf("%s.%s", def.name, field.name), refs, field); err != nil {
+				errs.add(field.pos, "%s", err)
 			}
 		}
 	}
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+
+	// Resolve views, and verify worksheets conform to the views they implement
+	for _, def := range defs {
+		if err := def.resolveImplements(views); err != nil {
+			errs = append(errs, err)
+		}
+	}
 
 	// Resolve computed_by dependencies
 	for _, def := range defs {
@@ -127,48 +340,219 @@ func NewDefinitions(reader io.Reader, opts ...Options) (*Definitions, error) {
 				fieldName := field.name
 				args := field.computedBy.Args()
 				if len(args) == 0 {
-					return nil, fmt.Errorf("%s.%s has no dependencies", def.name, fieldName)
+					errs.add(field.pos, "%s.%s has no dependencies", def.name, fieldName)
 				}
 				for _, argName := range args {
 					dependent, ok := def.fieldsByName[argName]
 					if !ok {
-						return nil, fmt.Errorf("%s.%s references unknown arg %s", def.name, fieldName, argName)
+						errs.add(field.pos, "%s.%s references unknown arg %s", def.name, fieldName, argName)
+						continue
 					}
 					def.dependents[dependent.index] = append(def.dependents[dependent.index], field.index)
 				}
 			}
+			if field.constrainedBy != nil {
+				for _, argName := range field.constrainedBy.Args() {
+					if _, ok := def.fieldsByName[argName]; !ok {
+						errs.add(field.pos, "%s.%s references unknown arg %s", def.name, field.name, argName)
+					}
+				}
+			}
 		}
 	}
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+
+	// Check types
+	for _, def := range defs {
+		errs = append(errs, def.checkTypes()...)
+	}
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+
+	// Order computed fields topologically, and resolve keys
+	for _, def := range defs {
+		if err := def.sortComputedFields(); err != nil {
+			errs = append(errs, err)
+		}
+		if err := def.resolveKey(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
 
 	return &Definitions{
 		defs: defs,
 	}, nil
 }
 
-func resolveRefTypes(niceFieldName string, defs map[string]*Definition, locus interface{}) error {
+// resolveKey resolves the fields forming the key of the definition, and
+// determines which fields are frozen once worksheets are in a map.
+func (def *Definition) resolveKey() *DefinitionError {
+	def.frozenFields = make(map[int]bool)
+
+	var freeze func(field *Field)
+	freeze = func(field *Field) {
+		def.frozenFields[field.index] = true
+		if field.computedBy != nil {
+			for _, argName := range field.computedBy.Args() {
+				freeze(def.fieldsByName[argName])
+			}
+		}
+	}
+
+	for _, name := range def.keyedBy {
+		field, ok := def.fieldsByName[name]
+		if !ok {
+			return definitionErrorf(def.pos, "%s: keyed_by unknown field %s", def.name, name)
+		}
+		if !isBaseType(field.typ) {
+			return definitionErrorf(field.pos, "%s: keyed_by field %s must be of base type, was %s", def.name, name, field.typ)
+		}
+		for _, keyField := range def.keyFields {
+			if keyField == field {
+				return definitionErrorf(def.pos, "%s: keyed_by field %s listed more than once", def.name, name)
+			}
+		}
+		def.keyFields = append(def.keyFields, field)
+		freeze(field)
+	}
+
+	return nil
+}
+
+// sortComputedFields orders the computed fields of the definition such that
+// all computed fields come after the computed fields they depend on, and
+// rejects cycles among computed fields.
+func (def *Definition) sortComputedFields() *DefinitionError {
+	var (
+		visited  = make(map[int]bool)
+		visiting = make(map[int]bool)
+		path     []string
+		visit    func(field *Field) *DefinitionError
+	)
+	visit = func(field *Field) *DefinitionError {
+		if visited[field.index] {
+			return nil
+		}
+		path = append(path, field.name)
+		if visiting[field.index] {
+			var start int
+			for path[start] != field.name {
+				start++
+			}
+			return definitionErrorf(field.pos, "%s.%s: computed_by cycle %s", def.name, field.name, strings.Join(path[start:], " -> "))
+		}
+		visiting[field.index] = true
+
+		for _, argName := range field.computedBy.Args() {
+			arg := def.fieldsByName[argName]
+			if arg.computedBy != nil {
+				if err := visit(arg); err != nil {
+					return err
+				}
+			}
+		}
+
+		path = path[:len(path)-1]
+		visited[field.index] = true
+		def.computedFields = append(def.computedFields, field)
+		return nil
+	}
+
+	for _, field := range def.fields {
+		if field.computedBy != nil {
+			if err := visit(field); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// resolveImplements resolves the views implemented by the definition, and
+// verifies that the definition has all the fields of these views, with
+// assignable types.
+func (def *Definition) resolveImplements(views map[string]*View) *DefinitionError {
+	for i, unresolved := range def.implements {
+		view, ok := views[unresolved.name]
+		if !ok {
+			return definitionErrorf(def.pos, "%s: implements unknown view %s", def.name, unresolved.name)
+		}
+		for _, other := range def.implements[:i] {
+			if other == view {
+				return definitionErrorf(def.pos, "%s: implements view %s more than once", def.name, view.name)
+			}
+		}
+		def.implements[i] = view
+
+		for _, viewField := range view.fields {
+			field, ok := def.fieldsByName[viewField.name]
+			if !ok {
+				return definitionErrorf(def.pos, "%s: does not implement %s, missing field %s", def.name, view.name, viewField.name)
+			}
+			if !field.typ.AssignableTo(viewField.typ) {
+				return definitionErrorf(field.pos, "%s: does not implement %s, field %s is %s not %s", def.name, view.name, field.name, field.typ, viewField.typ)
+			}
+		}
+	}
+
+	return nil
+}
+
+// resolveRefTypes replaces references to worksheets or views by name, which
+// the parser produces, with the referenced definitions or views.
+func resolveRefTypes(niceFieldName string, refs map[string]Type, locus interface{}) error {
 	switch locus.(type) {
 	case *Field:
 		field := locus.(*Field)
 		if refTyp, ok := field.typ.(*Definition); ok {
-			refDef, ok := defs[refTyp.name]
+			ref, ok := refs[refTyp.name]
 			if !ok {
 				return fmt.Errorf("%s: unknown worksheet %s referenced", niceFieldName, refTyp.name)
 			}
-			field.typ = refDef
+			field.typ = ref
+		}
+		switch field.typ.(type) {
+		case *SliceType, *MapType:
+			return resolveRefTypes(niceFieldName, refs, field.typ)
+		}
+	case *MapType:
+		mapType := locus.(*MapType)
+		refTyp, ok := mapType.elementType.(*Definition)
+		if !ok {
+			return fmt.Errorf("%s: map of non-worksheet type %s", niceFieldName, mapType.elementType)
+		}
+		ref, ok := refs[refTyp.name]
+		if !ok {
+			return fmt.Errorf("%s: unknown worksheet %s referenced", niceFieldName, refTyp.name)
+		}
+		if _, ok := ref.(*View); ok {
+			return fmt.Errorf("%s: map of view %s, maps require keyed worksheets", niceFieldName, refTyp.name)
 		}
-		if _, ok := field.typ.(*SliceType); ok {
-			return resolveRefTypes(niceFieldName, defs, field.typ)
+		refDef, ok := ref.(*Definition)
+		if !ok {
+			return fmt.Errorf("%s: map of non-worksheet type %s", niceFieldName, refTyp.name)
 		}
+		if len(refDef.keyedBy) == 0 {
+			return fmt.Errorf("%s: map of non-keyed worksheet %s", niceFieldName, refTyp.name)
+		}
+		mapType.elementType = refDef
 	case *SliceType:
 		sliceType := locus.(*SliceType)
 		if refTyp, ok := sliceType.elementType.(*Definition); ok {
-			refDef, ok := defs[refTyp.name]
+			ref, ok := refs[refTyp.name]
 			if !ok {
 				return fmt.Errorf("%s: unknown worksheet %s referenced", niceFieldName, refTyp.name)
 			}
-			sliceType.elementType = refDef
+			sliceType.elementType = ref
 		}
-		return resolveRefTypes(niceFieldName, defs, sliceType.elementType)
+		return resolveRefTypes(niceFieldName, refs, sliceType.elementType)
 	}
 
 	return nil
@@ -193,6 +577,14 @@ func processOptions(defs map[string]*Definition, opts ...Options) error {
 			return err
 		}
 	}
+
+	for name, reactors := range opt.Reactors {
+		def, ok := defs[name]
+		if !ok {
+			return fmt.Errorf("reactors: unknown worksheet(%s)", name)
+		}
+		def.reactors = append(def.reactors, reactors...)
+	}
 	return nil
 }
 
@@ -210,6 +602,12 @@ func attachPluginsToFields(def *Definition, plugins map[string]ComputedBy) error
 	return nil
 }
 
+// Definition returns the definition of the worksheet with the given name, or
+// nil if there is no such worksheet.
+func (defs *Definitions) Definition(name string) *Definition {
+	return defs.defs[name]
+}
+
 func (defs *Definitions) MustNewWorksheet(name string) *Worksheet {
 	ws, err := defs.NewWorksheet(name)
 	if err != nil {
@@ -300,27 +698,10 @@ func (ws *Worksheet) MustSet(name string, value Value) {
 	}
 }
 
+// Set sets field name to value. It is a shorthand for applying an edit block
+// with a single individual edit.
 func (ws *Worksheet) Set(name string, value Value) error {
-	// TODO(pascal): create a 'change', and then commit that change, garantee
-	// that commits are atomic, and either win or lose the race by using
-	// optimistic concurrency. Change must be a a Definition level, since it
-	// could span multiple worksheets at once.
-
-	// lookup field by name
-	field, ok := ws.def.fieldsByName[name]
-	if !ok {
-		return fmt.Errorf("unknown field %s", name)
-	}
-
-	if field.computedBy != nil {
-		return fmt.Errorf("cannot assign to computed field %s", name)
-	}
-
-	if _, ok := field.typ.(*SliceType); ok {
-		return fmt.Errorf("Set on slice field %s, use Append, or Del", name)
-	}
-
-	err := ws.set(field, value)
+	_, err := ws.Apply(NewEdit().Set(name, value))
 	return err
 }
 
@@ -341,6 +722,9 @@ func (ws *Worksheet) set(field *Field, value Value) error {
 	if ok := litType.AssignableTo(field.typ); !ok {
 		return fmt.Errorf("cannot assign value of type %s to field of type %s", litType, field.typ)
 	}
+	if err := checkEnumMembership(value, field.typ); err != nil {
+		return err
+	}
 
 	// store
 	if value.Type().AssignableTo(&tUndefinedType{}) {
@@ -349,16 +733,127 @@ func (ws *Worksheet) set(field *Field, value Value) error {
 		ws.data[index] = value
 	}
 
-	// if this field is an ascendant to any other, recompute them
-	for _, dependentIndex := range ws.def.dependents[index] {
-		dependent := ws.def.fieldsByIndex[dependentIndex]
-		updatedValue, err := dependent.computedBy.Compute(ws)
+	return nil
+}
+
+// checkEnumMembership verifies that a text assigned to an enum is one of the
+// enum's allowed values.
+func checkEnumMembership(value Value, typ Type) error {
+	enum, ok := typ.(*EnumType)
+	if !ok {
+		return nil
+	}
+	text, ok := value.(*Text)
+	if !ok {
+		return nil
+	}
+	if !enum.has(text.value) {
+		return fmt.Errorf("%s is not a value of enum %s", text, enum.name)
+	}
+	return nil
+}
+
+// recompute recomputes all computed fields affected by a change, i.e. whose
+// arguments differ from the data before the change. Fields are recomputed in
+// topological order, such that each field is computed exactly once, and only
+// ever observes the final value of its arguments.
+func (ws *Worksheet) recompute(before map[int]Value) error {
+	changed := make(map[int]bool)
+	for index := range diffData(before, ws.data) {
+		changed[index] = true
+	}
+	return ws.recomputeChanged(changed)
+}
+
+// recomputeChanged recomputes all computed fields affected by a change to the
+// fields at the changed indexes. The map is updated as fields are recomputed.
+func (ws *Worksheet) recomputeChanged(changed map[int]bool) error {
+	for _, field := range ws.def.computedFields {
+		affected := false
+		for _, argName := range field.computedBy.Args() {
+			affected = affected || changed[ws.def.fieldsByName[argName].index]
+		}
+		if !affected {
+			continue
+		}
+
+		updatedValue, err := field.computedBy.Compute(ws, nil)
 		if err != nil {
 			return err
 		}
-		if err := ws.set(dependent, updatedValue); err != nil {
+		oldValue, hadValue := ws.data[field.index]
+		if err := ws.set(field, updatedValue); err != nil {
+			return err
+		}
+		newValue, hasValue := ws.data[field.index]
+		if hadValue != hasValue || (hadValue && !oldValue.Equal(newValue)) {
+			changed[field.index] = true
+		}
+	}
+
+	return nil
+}
+
+// ConstraintError is returned when an edit does not satisfy the constraint of
+// a field.
+type ConstraintError struct {
+	// Worksheet is the name of the worksheet being edited.
+	Worksheet string
+
+	// Field is the name of the field whose constraint is not satisfied.
+	Field string
+}
+
+func (e *ConstraintError) Error() string {
+	return fmt.Sprintf("%s.%s: constraint not satisfied", e.Worksheet, e.Field)
+}
+
+// checkConstraints verifies the constraints of all fields which are affected
+// by a change, i.e. the field itself or any of its constraint's arguments
+// differ from the data before the change.
+//
+// Constraints evaluating to undefined are considered satisfied, since we
+// cannot determine whether they hold.
+func (ws *Worksheet) checkConstraints(before map[int]Value) error {
+	changed := func(index int) bool {
+		b, hasBefore := before[index]
+		a, hasAfter := ws.data[index]
+		if hasBefore != hasAfter {
+			return true
+		}
+		return hasBefore && !b.Equal(a)
+	}
+
+	for _, field := range ws.def.fields {
+		if field.constrainedBy == nil {
+			continue
+		}
+
+		affected := changed(field.index)
+		for _, argName := range field.constrainedBy.Args() {
+			affected = affected || changed(ws.def.fieldsByName[argName].index)
+		}
+		if !affected {
+			continue
+		}
+
+		result, err := field.constrainedBy.Compute(ws, nil)
+		if err != nil {
 			return err
 		}
+		switch r := result.(type) {
+		case *Undefined:
+			// satisfied
+		case *Bool:
+			if !r.value {
+				return &ConstraintError{
+					Worksheet: ws.def.name,
+					Field:     field.name,
+				}
+			}
+		default:
+			return fmt.Errorf("%s.%s: constrained_by must evaluate to bool, was %s", ws.def.name, field.name, result.Type())
+		}
 	}
 
 	return nil
@@ -371,12 +866,8 @@ func (ws *Worksheet) MustUnset(name string) {
 }
 
 func (ws *Worksheet) Unset(name string) error {
-	if field, ok := ws.def.fieldsByName[name]; ok {
-		if _, ok := field.typ.(*SliceType); ok {
-			return fmt.Errorf("Unset on slice field names, must use Del")
-		}
-	}
-	return ws.Set(name, NewUndefined())
+	_, err := ws.Apply(NewEdit().Unset(name))
+	return err
 }
 
 func (ws *Worksheet) MustIsSet(name string) bool {
@@ -461,6 +952,10 @@ func (ws *Worksheet) Get(name string) (Value, error) {
 		return nil, fmt.Errorf("Get on slice field %s, use GetSlice", name)
 	}
 
+	if _, ok := field.typ.(*MapType); ok {
+		return nil, fmt.Errorf("Get on map field %s, use GetMap", name)
+	}
+
 	return value, err
 }
 
@@ -488,61 +983,160 @@ func (ws *Worksheet) MustAppend(name string, value Value) {
 }
 
 func (ws *Worksheet) Append(name string, element Value) error {
-	// lookup field by name
-	field, ok := ws.def.fieldsByName[name]
-	if !ok {
-		return fmt.Errorf("unknown field %s", name)
-	}
-	index := field.index
+	_, err := ws.Apply(NewEdit().Append(name, element))
+	return err
+}
 
-	sliceType, ok := field.typ.(*SliceType)
-	if !ok {
-		return fmt.Errorf("Append on non-slice field %s", name)
+func (ws *Worksheet) MustDel(name string, index int) {
+	if err := ws.Del(name, index); err != nil {
+		panic(err)
 	}
+}
 
-	// is a value set for this field?
-	value, ok := ws.data[index]
-	if !ok {
-		value = newSlice(sliceType)
-		ws.data[index] = value
+func (ws *Worksheet) Del(name string, index int) error {
+	_, err := ws.Apply(NewEdit().Del(name, index))
+	return err
+}
+
+func (ws *Worksheet) MustPut(name string, value Value) {
+	if err := ws.Put(name, value); err != nil {
+		panic(err)
 	}
+}
 
-	// append
-	slice := value.(*slice)
-	slice, err := slice.doAppend(element)
-	if err != nil {
-		return err
+// Put puts a worksheet in the map field name, under the worksheet's key.
+func (ws *Worksheet) Put(name string, value Value) error {
+	_, err := ws.Apply(NewEdit().Put(name, value))
+	return err
+}
+
+func (ws *Worksheet) MustDelKey(name string, key ...Value) {
+	if err := ws.DelKey(name, key...); err != nil {
+		panic(err)
 	}
-	ws.data[index] = slice
+}
 
-	return nil
+// DelKey deletes the worksheet with the given key from the map field name.
+// Keys of worksheets keyed by multiple fields are given in the order of the
+// keyed_by fields, or as a tuple.
+func (ws *Worksheet) DelKey(name string, key ...Value) error {
+	_, err := ws.Apply(NewEdit().DelKey(name, key...))
+	return err
 }
 
-func (ws *Worksheet) MustDel(name string, index int) {
-	if err := ws.Del(name, index); err != nil {
+func (ws *Worksheet) MustHas(name string, key ...Value) bool {
+	has, err := ws.Has(name, key...)
+	if err != nil {
 		panic(err)
 	}
+	return has
 }
 
-func (ws *Worksheet) Del(name string, index int) error {
-	field, slice, err := ws.getSlice(name)
+// Has reports whether the map field name holds a worksheet with the given key.
+func (ws *Worksheet) Has(name string, key ...Value) (bool, error) {
+	field, m, err := ws.getMap(name)
 	if err != nil {
 		if field != nil {
-			if _, ok := field.typ.(*SliceType); !ok {
-				return fmt.Errorf("Del on non-slice field %s", name)
+			if _, ok := field.typ.(*MapType); !ok {
+				return false, fmt.Errorf("Has on non-map field %s", name)
 			}
 		}
-		return err
+		return false, err
 	}
 
-	slice, err = slice.doDel(index)
+	k, err := field.typ.(*MapType).elementType.(*Definition).keyOf(key)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	ws.data[field.index] = slice
+	return m.find(k) != -1, nil
+}
 
-	return nil
+func (ws *Worksheet) MustGetMap(name string) []Value {
+	values, err := ws.GetMap(name)
+	if err != nil {
+		panic(err)
+	}
+	return values
+}
+
+// GetMap gets the worksheets of the map field name, in the order they were
+// put in the map.
+func (ws *Worksheet) GetMap(name string) ([]Value, error) {
+	_, m, err := ws.getMap(name)
+	if err != nil {
+		return nil, err
+	} else if m == nil {
+		return nil, nil
+	}
+
+	var values []Value
+	for _, entry := range m.entries {
+		values = append(values, entry.value)
+	}
+	return values, nil
+}
+
+func (ws *Worksheet) getMap(name string) (*Field, *mapValue, error) {
+	field, value, err := ws.get(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, ok := field.typ.(*MapType); !ok {
+		return field, nil, fmt.Errorf("GetMap on non-map field %s", name)
+	}
+
+	if _, ok := value.(*Undefined); ok {
+		return field, nil, nil
+	}
+
+	return field, value.(*mapValue), nil
+}
+
+// key returns the key of the worksheet, under which it is put in maps.
+func (ws *Worksheet) key() (string, error) {
+	values := make([]Value, len(ws.def.keyFields))
+	for i, field := range ws.def.keyFields {
+		value, ok := ws.data[field.index]
+		if !ok {
+			return "", fmt.Errorf("%s: key field %s is undefined", ws.def.name, field.name)
+		}
+		values[i] = value
+	}
+	return ws.def.keyOf(values)
+}
+
+// keyOf returns the key formed by values, in the order of the keyed_by
+// fields. Keys of worksheets keyed by multiple fields can also be given as a
+// single tuple. Numbers are brought to the scale of their field, such that
+// equal numbers yield the same key.
+func (def *Definition) keyOf(values []Value) (string, error) {
+	if len(values) == 1 && len(def.keyFields) != 1 {
+		if tuple, ok := values[0].(*Tuple); ok {
+			values = tuple.values
+		}
+	}
+	if len(values) != len(def.keyFields) {
+		return "", fmt.Errorf("%s: key has %d fields, %d given", def.name, len(def.keyFields), len(values))
+	}
+
+	parts := make([]string, len(values))
+	for i, value := range values {
+		field := def.keyFields[i]
+		if !value.Type().AssignableTo(field.typ) {
+			return "", fmt.Errorf("%s: key field %s of type %s, %s given", def.name, field.name, field.typ, value.Type())
+		}
+		if num, ok := value.(*Number); ok {
+			rounded, err := num.Round(ModeDown, field.typ.(*tNumberType).scale)
+			if err != nil {
+				return "", err
+			}
+			value = rounded
+		}
+		parts[i] = value.String()
+	}
+	return strings.Join(parts, ", "), nil
 }
 
 type change struct {
@@ -550,18 +1144,22 @@ type change struct {
 }
 
 func (ws *Worksheet) diff() map[int]change {
+	return diffData(ws.orig, ws.data)
+}
+
+func diffData(before, after map[int]Value) map[int]change {
 	allIndexes := make(map[int]bool)
-	for index := range ws.orig {
+	for index := range before {
 		allIndexes[index] = true
 	}
-	for index := range ws.data {
+	for index := range after {
 		allIndexes[index] = true
 	}
 
 	diff := make(map[int]change)
 	for index := range allIndexes {
-		orig, hasOrig := ws.orig[index]
-		data, hasData := ws.data[index]
+		orig, hasOrig := before[index]
+		data, hasData := after[index]
 		if hasOrig && !hasData {
 			diff[index] = change{
 				before: orig,
@@ -583,6 +1181,40 @@ func (ws *Worksheet) diff() map[int]change {
 	return diff
 }
 
+func diffMaps(before, after *mapValue) ([]int, []mapEntry) {
+	var (
+		b, a         int
+		ranksOfDels  []int
+		entriesAdded []mapEntry
+	)
+	for b < len(before.entries) && a < len(after.entries) {
+		bEntry, aEntry := before.entries[b], after.entries[a]
+		if bEntry.rank == aEntry.rank {
+			if bEntry.value != aEntry.value {
+				// we've replaced the worksheet at this rank
+				// represent as a delete and an add
+				ranksOfDels = append(ranksOfDels, bEntry.rank)
+				entriesAdded = append(entriesAdded, aEntry)
+			}
+			b++
+			a++
+		} else if bEntry.rank < aEntry.rank {
+			ranksOfDels = append(ranksOfDels, bEntry.rank)
+			b++
+		} else if aEntry.rank < bEntry.rank {
+			entriesAdded = append(entriesAdded, aEntry)
+			a++
+		}
+	}
+	for ; b < len(before.entries); b++ {
+		ranksOfDels = append(ranksOfDels, before.entries[b].rank)
+	}
+	for ; a < len(after.entries); a++ {
+		entriesAdded = append(entriesAdded, after.entries[a])
+	}
+	return ranksOfDels, entriesAdded
+}
+
 func diffSlices(before, after *slice) ([]int, []sliceElement) {
 	var (
 		b, a          int
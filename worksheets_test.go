@@ -53,37 +53,37 @@ func (s *Zuite) TestNewDefinitionsErrors() {
 	cases := map[string]string{
 		// crap input
 		``:                `expecting worksheet`,
-		` `:               `expecting worksheet`,
-		`some text`:       `expecting worksheet`,
-		`not a worksheet`: `expecting worksheet`,
-		`work sheet`:      `expecting worksheet`,
+		` `:               `1:2: expecting worksheet`,
+		`some text`:       `1:1: expecting worksheet`,
+		`not a worksheet`: "1:1: expecting worksheet\n1:16: expected name, found ",
+		`work sheet`:      `1:1: expecting worksheet`,
 
 		// worksheet semantics
 		`worksheet simple {
 			0:no_can_do_with_zero bool
-		}`: `simple.no_can_do_with_zero: index cannot be zero`,
+		}`: `2:4: simple.no_can_do_with_zero: index cannot be zero`,
 
 		`worksheet simple {
 			42:full_name text
 			42:happy bool
-		}`: `simple.happy: index 42 cannot be reused`,
+		}`: `3:4: simple.happy: index 42 cannot be reused`,
 
 		`worksheet simple {
 			42:same_name text
 			43:same_name text
-		}`: `simple.same_name: multiple fields named same_name`,
+		}`: `3:4: simple.same_name: multiple fields named same_name`,
 
 		`worksheet ref_to_worksheet {
 			89:ref_here some_other_worksheet
-		}`: `ref_to_worksheet.ref_here: unknown worksheet some_other_worksheet referenced`,
+		}`: `2:4: ref_to_worksheet.ref_here: unknown worksheet some_other_worksheet referenced`,
 
 		`worksheet refs_to_worksheet {
 			89:refs_here []some_other_worksheet
-		}`: `refs_to_worksheet.refs_here: unknown worksheet some_other_worksheet referenced`,
+		}`: `2:4: refs_to_worksheet.refs_here: unknown worksheet some_other_worksheet referenced`,
 
 		`worksheet refs_to_worksheet {
 			89:refs_here [][]some_other_worksheet
-		}`: `refs_to_worksheet.refs_here: unknown worksheet some_other_worksheet referenced`,
+		}`: `2:4: refs_to_worksheet.refs_here: unknown worksheet some_other_worksheet referenced`,
 	}
 	for input, msg := range cases {
 		_, err := NewDefinitions(strings.NewReader(input))
@@ -107,10 +107,82 @@ func (s *Zuite) TestWorksheetNew_multipleDefsSameName() {
 	wsDefs := `worksheet simple {1:name text} worksheet simple {1:occupation text}`
 	_, err := NewDefinitions(strings.NewReader(wsDefs))
 	if assert.Error(s.T(), err) {
-		require.Equal(s.T(), "multiple worksheets with name simple", err.Error())
+		require.Equal(s.T(), "1:32: multiple worksheets with name simple", err.Error())
 	}
 }
 
+func (s *Zuite) TestNewDefinitionsErrors_positions() {
+	_, err := NewDefinitions(strings.NewReader(`worksheet simple {
+		1:name text
+		2:age number[0] computed_by {
+			return name +
+		}
+	}`))
+	require.IsType(s.T(), DefinitionErrors{}, err)
+
+	errs := err.(DefinitionErrors)
+	require.Len(s.T(), errs, 1)
+	assert.Equal(s.T(), 5, errs[0].Pos.Line)
+	assert.Equal(s.T(), 3, errs[0].Pos.Column)
+	assert.Equal(s.T(), "expecting expression", errs[0].Msg)
+	assert.EqualError(s.T(), err, "5:3: expecting expression")
+}
+
+type namedReader struct {
+	*strings.Reader
+	name string
+}
+
+func (r namedReader) Name() string {
+	return r.name
+}
+
+func (s *Zuite) TestNewDefinitionsErrors_filename() {
+	_, err := NewDefinitions(namedReader{strings.NewReader(`worksheet simple {
+		0:name text
+	}`), "simple.ws"})
+	require.EqualError(s.T(), err, "simple.ws:2:3: simple.name: index cannot be zero")
+}
+
+func (s *Zuite) TestNewDefinitionsErrors_multipleErrors() {
+	_, err := NewDefinitions(strings.NewReader(`
+	worksheet one {
+		1:name text
+		2:age number[0] computed_by { return name + }
+	}
+
+	worksheet two {
+		1 name text
+	}
+
+	worksheet three {
+		1:name text
+	}`))
+	require.EqualError(s.T(), err, strings.Join([]string{
+		`4:47: expecting expression`,
+		`8:5: expected :, found name`,
+	}, "\n"))
+
+	_, err = NewDefinitions(strings.NewReader(`
+	worksheet one {
+		0:name text
+		1:first text
+		1:last text
+		2:other unknown
+	}
+
+	worksheet two {
+		3:name text
+		3:nick text
+	}`))
+	require.EqualError(s.T(), err, strings.Join([]string{
+		`3:3: one.name: index cannot be zero`,
+		`5:3: one.last: index 1 cannot be reused`,
+		`6:3: one.other: unknown worksheet unknown referenced`,
+		`11:3: two.nick: index 3 cannot be reused`,
+	}, "\n"))
+}
+
 func (s *Zuite) TestWorksheetNew_origEmpty() {
 	defs, err := NewDefinitions(strings.NewReader(`worksheet simple {1:name text}`))
 	require.NoError(s.T(), err)